@@ -12,6 +12,10 @@ import (
 
 	"github.com/hhftechnology/traefik-relay/internal/api"
 	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/consul"
+	"github.com/hhftechnology/traefik-relay/internal/etcd"
+	"github.com/hhftechnology/traefik-relay/internal/file"
+	"github.com/hhftechnology/traefik-relay/internal/leader"
 	"github.com/hhftechnology/traefik-relay/internal/redis"
 	"github.com/hhftechnology/traefik-relay/internal/worker"
 )
@@ -19,10 +23,11 @@ import (
 func main() {
 	// Define command line flags
 	configPath := flag.String("config", getEnv("CONFIG_PATH", "/config.yml"), "Path to configuration file")
-	redisURL := flag.String("redis", getEnv("REDIS_URL", "redis:6379"), "Redis URL")
+	redisURL := flag.String("redis", getEnv("REDIS_URL", "redis:6379"), "Redis URL (empty to disable Redis publishing)")
 	runEvery := flag.Int("run-every", getIntEnv("RUN_EVERY", 60), "Run every N seconds")
 	apiPort := flag.Int("api-port", getIntEnv("API_PORT", 8080), "API server port")
 	enableAPI := flag.Bool("enable-api", getBoolEnv("ENABLE_API", true), "Enable API server")
+	leaderID := flag.String("leader-id", getEnv("LEADER_ID", ""), "Leader election ID of this replica (defaults to the hostname)")
 	flag.Parse()
 
 	// Load configuration
@@ -41,20 +46,116 @@ func main() {
 		cfg.RunEvery = *runEvery
 	}
 
-	// Initialize Redis client
-	redisClient, err := redis.NewClient(*redisURL)
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	// Initialize the key-value store the configuration is published to
+	var publishers []worker.Publisher
+	var redisClient *redis.Client
+	switch cfg.Publisher.Type {
+	case config.PublisherRedis:
+		// The address from config.yml takes precedence over REDIS_URL
+		address := cfg.Publisher.Address
+		if address == "" {
+			address = *redisURL
+		}
+		if address == "" {
+			log.Println("Redis publishing disabled")
+			break
+		}
+
+		redisClient, err = redis.NewClient(address, cfg.InstanceID)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		publishers = append(publishers, redisClient)
+	case config.PublisherConsul:
+		consulClient, err := consul.NewClient(cfg.Publisher.Address, cfg.Publisher.Token, cfg.InstanceID, cfg.Publisher.SplitTransactions)
+		if err != nil {
+			log.Fatalf("Failed to connect to Consul: %v", err)
+		}
+		publishers = append(publishers, consulClient)
+	case config.PublisherEtcd:
+		etcdClient, err := etcd.NewClient(cfg.Publisher.Address, cfg.Publisher.Username, cfg.Publisher.Password, cfg.InstanceID,
+			cfg.Publisher.MaxTxnOps, cfg.Publisher.SplitTransactions)
+		if err != nil {
+			log.Fatalf("Failed to connect to etcd: %v", err)
+		}
+		publishers = append(publishers, etcdClient)
 	}
-	defer redisClient.Close()
 
-	// Flush Redis DB on startup to clear old configurations
-	if err := redisClient.FlushDB(); err != nil {
-		log.Fatalf("Failed to flush Redis database: %v", err)
+	// Initialize the file output if configured
+	if cfg.File.Path != "" {
+		fileWriter, err := file.NewWriter(cfg.File.Path, cfg.File.Format)
+		if err != nil {
+			log.Fatalf("Failed to set up file output: %v", err)
+		}
+		publishers = append(publishers, fileWriter)
+		log.Printf("Writing dynamic configuration to '%s'", cfg.File.Path)
+	}
+
+	defer func() {
+		for _, publisher := range publishers {
+			publisher.Close()
+		}
+	}()
+
+	// Without a publisher the API is the only way to hand the configuration to Traefik
+	if len(publishers) == 0 && !*enableAPI {
+		log.Fatal("No publisher configured and the API is disabled. Enable at least one of them.")
+	}
+
+	// Weights of traffic splits changed at runtime are kept in Redis when available
+	var splitStore worker.SplitStore
+	if redisClient != nil {
+		splitStore = redisClient
+	}
+
+	// Set up leader election so that only one replica publishes
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled {
+		// The lease lives in Redis, even when publishing to another store
+		leaseClient := redisClient
+		if leaseClient == nil {
+			if *redisURL == "" {
+				log.Fatal("Leader election requires Redis. Please set REDIS_URL.")
+			}
+			leaseClient, err = redis.NewClient(*redisURL, cfg.InstanceID)
+			if err != nil {
+				log.Fatalf("Failed to connect to Redis for leader election: %v", err)
+			}
+			defer leaseClient.Close()
+			splitStore = leaseClient
+		}
+
+		// Replicas default to their hostname, which is unique per container
+		id := *leaderID
+		if id == "" {
+			id = cfg.LeaderElection.ID
+		}
+		if id == "" {
+			id, err = os.Hostname()
+			if err != nil {
+				log.Fatalf("Failed to determine leader election ID: %v", err)
+			}
+		}
+
+		leaseKey := fmt.Sprintf("relay:%s:leader", cfg.InstanceID)
+		leaseDuration := time.Duration(cfg.LeaderElection.LeaseDuration) * time.Second
+		elector = leader.NewElector(leaseClient, leaseKey, id, leaseDuration)
+		log.Printf("Leader election enabled as '%s' with a lease of %s", id, leaseDuration)
 	}
 
 	// Create worker
-	w := worker.New(cfg, redisClient)
+	w := worker.New(cfg, publishers)
+	if splitStore != nil {
+		w.SetSplitStore(splitStore)
+	}
+
+	// The last known good entries of the servers are kept in the key-value store, if any
+	for _, publisher := range publishers {
+		if store, ok := publisher.(worker.RetainStore); ok {
+			w.SetRetainStore(store)
+			break
+		}
+	}
 
 	// Create context that will be canceled on SIGTERM or SIGINT
 	ctx, cancel := context.WithCancel(context.Background())
@@ -69,24 +170,52 @@ func main() {
 		cancel()
 	}()
 
+	// Campaign for leadership
+	var elected <-chan struct{}
+	electorDone := make(chan struct{})
+	if elector != nil {
+		elected = elector.Elected()
+		go func() {
+			elector.Run(ctx)
+			close(electorDone)
+		}()
+	}
+
 	// Run the worker
-	log.Printf("Starting TraefikRelay with %d servers", len(cfg.Servers))
+	log.Printf("Starting TraefikRelay instance '%s' with %d servers", cfg.InstanceID, len(cfg.Servers))
 	go func() {
 		ticker := time.NewTicker(time.Duration(cfg.RunEvery) * time.Second)
 		defer ticker.Stop()
 
-		// Run once immediately
-		if err := w.Execute(ctx); err != nil {
-			log.Printf("Error executing worker: %v", err)
+		// Followers only build and serve the entries until they are elected
+		execute := func() {
+			run := w.Execute
+			if elector != nil && !elector.IsLeader() {
+				run = w.Build
+			}
+			result, err := run(ctx)
+			if err != nil {
+				log.Printf("Error executing worker: %v", err)
+				return
+			}
+			log.Printf("Run completed in %s: %d entries, %d added, %d changed, %d removed",
+				result.Duration, result.Entries, len(result.Added), len(result.Changed), len(result.Removed))
 		}
 
+		// Run once immediately
+		execute()
+
 		// Then run periodically
 		for {
 			select {
 			case <-ticker.C:
-				if err := w.Execute(ctx); err != nil {
-					log.Printf("Error executing worker: %v", err)
-				}
+				execute()
+			case <-w.RunRequests():
+				execute()
+			case <-elected:
+				// The previous leader may have changed the store, so start from its state
+				w.Reset()
+				execute()
 			case <-ctx.Done():
 				log.Println("Worker stopped")
 				return
@@ -96,7 +225,7 @@ func main() {
 
 	// Start API server if enabled
 	if *enableAPI {
-		apiServer := api.NewServer(cfg, redisClient)
+		apiServer := api.NewServer(cfg, redisClient, w, elector)
 		go func() {
 			if err := apiServer.Start(*apiPort); err != nil {
 				log.Fatalf("API server error: %v", err)
@@ -108,6 +237,11 @@ func main() {
 	// Wait for termination signal
 	<-ctx.Done()
 	log.Println("Shutting down...")
+
+	// Hand over leadership before the Redis connection is closed
+	if elector != nil {
+		<-electorDone
+	}
 }
 
 func getEnv(key, fallback string) string {
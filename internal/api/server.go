@@ -1,8 +1,12 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,8 +19,11 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/dynamic"
+	"github.com/hhftechnology/traefik-relay/internal/leader"
 	"github.com/hhftechnology/traefik-relay/internal/redis"
 	"github.com/hhftechnology/traefik-relay/internal/traefik"
+	"github.com/hhftechnology/traefik-relay/internal/worker"
 )
 
 // Server represents the API server
@@ -24,6 +31,8 @@ type Server struct {
 	router      *chi.Mux
 	config      *config.Config
 	redisClient *redis.Client
+	worker      *worker.Worker
+	elector     *leader.Elector
 	statusInfo  *StatusInfo
 	mu          sync.RWMutex
 }
@@ -31,32 +40,41 @@ type Server struct {
 // StatusInfo holds the status information for all servers
 type StatusInfo struct {
 	LastUpdated time.Time                `json:"lastUpdated"`
+	Instance    string                   `json:"instance"`
 	Servers     map[string]*ServerStatus `json:"servers"`
+	Conflicts   []worker.Conflict        `json:"conflicts"`
+	Pending     *worker.PendingChange    `json:"pending,omitempty"`
+	Leader      *leader.Status           `json:"leader,omitempty"`
 }
 
 // ServerStatus holds the status information for a single server
 type ServerStatus struct {
-	Online        bool      `json:"online"`
-	LastChecked   time.Time `json:"lastChecked"`
-	HttpRouters   int       `json:"httpRouters"`
-	TcpRouters    int       `json:"tcpRouters"`
-	Middlewares   int       `json:"middlewares"`
-	Services      int       `json:"services"`
-	Error         string    `json:"error,omitempty"`
-	Configuration any       `json:"configuration"`
+	Online        bool                `json:"online"`
+	LastChecked   time.Time           `json:"lastChecked"`
+	HttpRouters   int                 `json:"httpRouters"`
+	TcpRouters    int                 `json:"tcpRouters"`
+	UdpRouters    int                 `json:"udpRouters"`
+	Middlewares   int                 `json:"middlewares"`
+	Services      int                 `json:"services"`
+	Error         string              `json:"error,omitempty"`
+	Configuration any                 `json:"configuration"`
+	Relay         *worker.ServerState `json:"relay,omitempty"`
 }
 
 // DetailedServerStatus holds detailed status information for a server
 type DetailedServerStatus struct {
 	ServerStatus
-	HttpRouters   []traefik.HttpRouter `json:"httpRouterDetails"`
-	TcpRouters    []traefik.TcpRouter  `json:"tcpRouterDetails"`
-	Middlewares   []traefik.Middleware `json:"middlewareDetails"`
-	Services      []traefik.Service    `json:"serviceDetails"`
+	HttpRouters []traefik.HttpRouter   `json:"httpRouterDetails"`
+	TcpRouters  []traefik.TcpRouter    `json:"tcpRouterDetails"`
+	UdpRouters  []traefik.UdpRouter    `json:"udpRouterDetails"`
+	Middlewares []traefik.Middleware   `json:"middlewareDetails"`
+	Services    []traefik.Service      `json:"serviceDetails"`
+	Skipped     []worker.SkippedRouter `json:"skippedRouters"`
 }
 
-// NewServer creates a new API server
-func NewServer(cfg *config.Config, redisClient *redis.Client) *Server {
+// NewServer creates a new API server. redisClient may be nil when Redis
+// publishing is disabled and elector when leader election is disabled.
+func NewServer(cfg *config.Config, redisClient *redis.Client, w *worker.Worker, elector *leader.Elector) *Server {
 	r := chi.NewRouter()
 
 	// Middleware
@@ -77,6 +95,7 @@ func NewServer(cfg *config.Config, redisClient *redis.Client) *Server {
 	// Create status info
 	statusInfo := &StatusInfo{
 		LastUpdated: time.Now(),
+		Instance:    cfg.InstanceID,
 		Servers:     make(map[string]*ServerStatus),
 	}
 
@@ -93,6 +112,8 @@ func NewServer(cfg *config.Config, redisClient *redis.Client) *Server {
 		router:      r,
 		config:      cfg,
 		redisClient: redisClient,
+		worker:      w,
+		elector:     elector,
 		statusInfo:  statusInfo,
 	}
 
@@ -134,6 +155,22 @@ func (s *Server) registerRoutes() {
 			r.Put("/", s.handleUpdateConfig)
 		})
 		
+		// Worker endpoints
+		r.Get("/runs/last", s.handleGetLastRun)
+		r.Get("/pending", s.handleGetPending)
+		r.Post("/pending/approve", s.handleApprovePending)
+
+		// Traffic split endpoints
+		r.Route("/splits", func(r chi.Router) {
+			r.Get("/", s.handleGetSplits)
+			r.Get("/{splitName}", s.handleGetSplit)
+			r.Put("/{splitName}", s.handleUpdateSplit)
+			r.Delete("/{splitName}", s.handleResetSplit)
+		})
+
+		// Traefik HTTP provider endpoint
+		r.Get("/provider", s.handleGetProviderConfig)
+
 		// Redis endpoints
 		r.Route("/redis", func(r chi.Router) {
 			r.Get("/keys", s.handleGetRedisKeys)
@@ -208,6 +245,14 @@ func (s *Server) updateAllServerStatus() {
 			continue
 		}
 
+		// Check UDP routers
+		udpRouters, err := client.GetUdpRouters(ctx)
+		if err != nil {
+			status.Online = false
+			status.Error = fmt.Sprintf("Failed to get UDP routers: %v", err)
+			continue
+		}
+
 		// Check middlewares
 		middlewares, err := client.GetMiddlewares(ctx)
 		if err != nil {
@@ -229,6 +274,7 @@ func (s *Server) updateAllServerStatus() {
 		status.Error = ""
 		status.HttpRouters = len(httpRouters)
 		status.TcpRouters = len(tcpRouters)
+		status.UdpRouters = len(udpRouters)
 		status.Middlewares = len(middlewares)
 		status.Services = len(services)
 	}
@@ -241,7 +287,16 @@ func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	writeJSON(w, s.statusInfo, http.StatusOK)
+	status := *s.statusInfo
+	status.Servers = s.serverStatuses()
+	status.Conflicts = s.worker.Conflicts()
+	status.Pending = s.worker.Pending()
+	if s.elector != nil {
+		leaderStatus := s.elector.Status()
+		status.Leader = &leaderStatus
+	}
+
+	writeJSON(w, status, http.StatusOK)
 }
 
 // handleGetServers handles the GET /api/v1/servers endpoint
@@ -249,7 +304,23 @@ func (s *Server) handleGetServers(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	writeJSON(w, s.statusInfo.Servers, http.StatusOK)
+	writeJSON(w, s.serverStatuses(), http.StatusOK)
+}
+
+// serverStatuses returns a copy of the server statuses including the relay
+// state reported by the worker. The caller must hold s.mu.
+func (s *Server) serverStatuses() map[string]*ServerStatus {
+	relayStates := s.worker.ServerStates()
+
+	statuses := make(map[string]*ServerStatus, len(s.statusInfo.Servers))
+	for name, status := range s.statusInfo.Servers {
+		serverStatus := *status
+		if relayState, ok := relayStates[name]; ok {
+			serverStatus.Relay = &relayState
+		}
+		statuses[name] = &serverStatus
+	}
+	return statuses
 }
 
 // handleGetServerDetail handles the GET /api/v1/servers/{serverName} endpoint
@@ -288,12 +359,16 @@ func (s *Server) handleGetServerDetail(w http.ResponseWriter, r *http.Request) {
 	detailedStatus := DetailedServerStatus{
 		ServerStatus: *serverStatus,
 	}
+	if relayState, ok := s.worker.ServerStates()[serverName]; ok {
+		detailedStatus.Relay = &relayState
+	}
+	detailedStatus.Skipped = s.worker.Skipped(serverName)
 
 	// Fetch detailed information
 	var wg sync.WaitGroup
-	var errHttp, errTcp, errMiddleware, errService error
+	var errHttp, errTcp, errUdp, errMiddleware, errService error
 
-	wg.Add(4)
+	wg.Add(5)
 	
 	// Fetch HTTP routers
 	go func() {
@@ -317,6 +392,17 @@ func (s *Server) handleGetServerDetail(w http.ResponseWriter, r *http.Request) {
 		detailedStatus.TcpRouters = tcpRouters
 	}()
 
+	// Fetch UDP routers
+	go func() {
+		defer wg.Done()
+		udpRouters, err := client.GetUdpRouters(ctx)
+		if err != nil {
+			errUdp = err
+			return
+		}
+		detailedStatus.UdpRouters = udpRouters
+	}()
+
 	// Fetch middlewares
 	go func() {
 		defer wg.Done()
@@ -342,7 +428,7 @@ func (s *Server) handleGetServerDetail(w http.ResponseWriter, r *http.Request) {
 	wg.Wait()
 
 	// Check for errors
-	if errHttp != nil || errTcp != nil || errMiddleware != nil || errService != nil {
+	if errHttp != nil || errTcp != nil || errUdp != nil || errMiddleware != nil || errService != nil {
 		detailedStatus.Error = "Failed to fetch some data"
 		if errHttp != nil {
 			detailedStatus.Error += fmt.Sprintf("; HTTP routers: %v", errHttp)
@@ -350,6 +436,9 @@ func (s *Server) handleGetServerDetail(w http.ResponseWriter, r *http.Request) {
 		if errTcp != nil {
 			detailedStatus.Error += fmt.Sprintf("; TCP routers: %v", errTcp)
 		}
+		if errUdp != nil {
+			detailedStatus.Error += fmt.Sprintf("; UDP routers: %v", errUdp)
+		}
 		if errMiddleware != nil {
 			detailedStatus.Error += fmt.Sprintf("; Middlewares: %v", errMiddleware)
 		}
@@ -418,6 +507,16 @@ func (s *Server) handleRefreshServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check UDP routers
+	udpRouters, err := client.GetUdpRouters(ctx)
+	if err != nil {
+		status.Online = false
+		status.Error = fmt.Sprintf("Failed to get UDP routers: %v", err)
+		s.mu.Unlock()
+		writeJSON(w, map[string]string{"status": "error", "message": status.Error}, http.StatusOK)
+		return
+	}
+
 	// Check middlewares
 	middlewares, err := client.GetMiddlewares(ctx)
 	if err != nil {
@@ -443,6 +542,7 @@ func (s *Server) handleRefreshServer(w http.ResponseWriter, r *http.Request) {
 	status.Error = ""
 	status.HttpRouters = len(httpRouters)
 	status.TcpRouters = len(tcpRouters)
+	status.UdpRouters = len(udpRouters)
 	status.Middlewares = len(middlewares)
 	status.Services = len(services)
 	s.mu.Unlock()
@@ -492,8 +592,149 @@ func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Not implemented", http.StatusNotImplemented)
 }
 
+// handleGetLastRun handles the GET /api/v1/runs/last endpoint
+func (s *Server) handleGetLastRun(w http.ResponseWriter, r *http.Request) {
+	result := s.worker.LastResult()
+	if result == nil {
+		http.Error(w, "No run completed yet", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, result, http.StatusOK)
+}
+
+// handleGetPending handles the GET /api/v1/pending endpoint
+func (s *Server) handleGetPending(w http.ResponseWriter, r *http.Request) {
+	pending := s.worker.Pending()
+	if pending == nil {
+		http.Error(w, "No pending change", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, pending, http.StatusOK)
+}
+
+// handleApprovePending handles the POST /api/v1/pending/approve endpoint
+func (s *Server) handleApprovePending(w http.ResponseWriter, r *http.Request) {
+	if !s.requireLeader(w) {
+		return
+	}
+
+	if !s.worker.ApprovePending() {
+		http.Error(w, "No pending change", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "success"}, http.StatusOK)
+}
+
+// handleGetSplits handles the GET /api/v1/splits endpoint
+func (s *Server) handleGetSplits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.worker.TrafficSplits(), http.StatusOK)
+}
+
+// handleGetSplit handles the GET /api/v1/splits/{splitName} endpoint
+func (s *Server) handleGetSplit(w http.ResponseWriter, r *http.Request) {
+	splitName := chi.URLParam(r, "splitName")
+	for _, split := range s.worker.TrafficSplits() {
+		if split.Name == splitName {
+			writeJSON(w, split, http.StatusOK)
+			return
+		}
+	}
+
+	http.Error(w, "Traffic split not found", http.StatusNotFound)
+}
+
+// handleUpdateSplit handles the PUT /api/v1/splits/{splitName} endpoint. Unless
+// the response reports them as persisted, the new weights apply until the relay restarts.
+func (s *Server) handleUpdateSplit(w http.ResponseWriter, r *http.Request) {
+	if !s.requireLeader(w) {
+		return
+	}
+
+	var body struct {
+		Weights map[string]int `json:"weights"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(body.Weights) == 0 {
+		http.Error(w, "No weights given", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.worker.SetSplitWeights(r.Context(), chi.URLParam(r, "splitName"), body.Weights); err != nil {
+		writeSplitError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"status": "success", "persisted": s.worker.SplitWeightsPersisted()}, http.StatusOK)
+}
+
+// handleResetSplit handles the DELETE /api/v1/splits/{splitName} endpoint,
+// which restores the weights from the configuration
+func (s *Server) handleResetSplit(w http.ResponseWriter, r *http.Request) {
+	if !s.requireLeader(w) {
+		return
+	}
+
+	if err := s.worker.ResetSplitWeights(r.Context(), chi.URLParam(r, "splitName")); err != nil {
+		writeSplitError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"status": "success", "persisted": s.worker.SplitWeightsPersisted()}, http.StatusOK)
+}
+
+// writeSplitError answers with the status matching a traffic split error
+func writeSplitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, worker.ErrUnknownSplit) {
+		http.Error(w, "Traffic split not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, worker.ErrSplitStore) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// handleGetProviderConfig handles the GET /api/v1/provider endpoint. The response
+// is a Traefik dynamic configuration suitable for providers.http.
+func (s *Server) handleGetProviderConfig(w http.ResponseWriter, r *http.Request) {
+	entries, updated, ok := s.worker.Entries()
+	if !ok {
+		// Answer with an error so Traefik keeps its current configuration
+		http.Error(w, "Configuration not computed yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := json.Marshal(dynamic.Build(entries))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+
+	// ServeContent sets Last-Modified and answers conditional requests
+	http.ServeContent(w, r, "", updated, bytes.NewReader(body))
+}
+
 // handleGetRedisKeys handles the GET /api/v1/redis/keys endpoint
 func (s *Server) handleGetRedisKeys(w http.ResponseWriter, r *http.Request) {
+	if s.redisClient == nil {
+		http.Error(w, "Redis is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -507,17 +748,43 @@ func (s *Server) handleGetRedisKeys(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, keys, http.StatusOK)
 }
 
-// handleFlushRedis handles the POST /api/v1/redis/flush endpoint
+// handleFlushRedis handles the POST /api/v1/redis/flush endpoint. Only the keys
+// owned by the relay are deleted, they are published again on the next run.
 func (s *Server) handleFlushRedis(w http.ResponseWriter, r *http.Request) {
-	// Flush Redis database
-	if err := s.redisClient.FlushDB(); err != nil {
+	if s.redisClient == nil {
+		http.Error(w, "Redis is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.requireLeader(w) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Delete the relay's keys
+	if err := s.redisClient.Clear(ctx); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to flush Redis: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// The worker only writes changes, so make it reload the now empty state
+	s.worker.Reset()
+
 	writeJSON(w, map[string]string{"status": "success"}, http.StatusOK)
 }
 
+// requireLeader answers with an error and returns false when this replica is a
+// follower, which only serves read-only endpoints
+func (s *Server) requireLeader(w http.ResponseWriter) bool {
+	if s.elector != nil && !s.elector.IsLeader() {
+		http.Error(w, "This replica is not the leader", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
 // writeJSON writes a JSON response
 func writeJSON(w http.ResponseWriter, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+	"github.com/hhftechnology/traefik-relay/internal/worker"
+)
+
+// newTestServer returns an API server whose worker relays the routers of a
+// fake Traefik instance
+func newTestServer(t *testing.T, routers []traefik.HttpRouter) (*Server, *worker.Worker) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/http/routers", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(routers)
+	})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	traefikAPI := httptest.NewServer(mux)
+	t.Cleanup(traefikAPI.Close)
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := fmt.Sprintf(`
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      web: web
+`, traefikAPI.URL)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	w := worker.New(cfg, nil)
+	return NewServer(cfg, nil, w, nil), w
+}
+
+// get serves a GET request with the given headers
+func get(s *Server, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleGetProviderConfig(t *testing.T) {
+	s, w := newTestServer(t, []traefik.HttpRouter{
+		{Name: "whoami@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`)"},
+	})
+
+	// Traefik keeps its configuration until the first run completed
+	if rec := get(s, "/api/v1/provider", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status before the first run = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	if _, err := w.Build(context.Background()); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	rec := get(s, "/api/v1/provider", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var body struct {
+		HTTP struct {
+			Routers map[string]struct {
+				EntryPoints []string `json:"entryPoints"`
+				Rule        string   `json:"rule"`
+				Service     string   `json:"service"`
+			} `json:"routers"`
+		} `json:"http"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error decoding %s: %v", rec.Body, err)
+	}
+	router, ok := body.HTTP.Routers["whoami_compute-1"]
+	if !ok || router.Rule != "Host(`whoami.lan`)" || router.Service != "compute-1" {
+		t.Errorf("configuration = %s, want the relayed router", rec.Body)
+	}
+
+	// Unchanged configurations are not sent again
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag header")
+	}
+	if rec := get(s, "/api/v1/provider", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+		t.Errorf("status with a matching ETag = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec := get(s, "/api/v1/provider", http.Header{"If-None-Match": {`"stale"`}}); rec.Code != http.StatusOK {
+		t.Errorf("status with a stale ETag = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
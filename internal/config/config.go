@@ -4,27 +4,175 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 
+	"github.com/hhftechnology/traefik-relay/internal/naming"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the main application configuration
 type Config struct {
-	Servers            []Server `yaml:"servers"`
-	RunEvery           int      `yaml:"runEvery"`
-	ForwardMiddlewares bool     `yaml:"forwardMiddlewares"`
-	ForwardServices    bool     `yaml:"forwardServices"`
+	InstanceID          string          `yaml:"instanceId"`
+	Servers             []Server        `yaml:"servers"`
+	RunEvery            int             `yaml:"runEvery"`
+	ForwardMiddlewares  bool            `yaml:"forwardMiddlewares"`
+	ForwardServices     bool            `yaml:"forwardServices"`
+	Publisher           Publisher       `yaml:"publisher"`
+	File                File            `yaml:"file"`
+	LeaderElection      LeaderElection  `yaml:"leaderElection"`
+	RetainOnError       RetainPolicy    `yaml:"retainOnError"`
+	DeletionGuard       DeletionGuard   `yaml:"deletionGuard"`
+	RouterNameTemplate  string          `yaml:"routerNameTemplate"`
+	ServiceNameTemplate string          `yaml:"serviceNameTemplate"`
+	Filters             Filters         `yaml:"filters"`
+	OptIn               OptIn           `yaml:"optIn"`
+	OnlyEnabledRouters  bool            `yaml:"onlyEnabledRouters"`
+	Failover            []FailoverGroup `yaml:"failover"`
+	TrafficSplits       []TrafficSplit  `yaml:"trafficSplits"`
+}
+
+// DeletionGuard holds back runs that would remove more than MaxRouters routers
+// or more than MaxPercent percent of the published keys. Zero disables a limit.
+type DeletionGuard struct {
+	MaxRouters int     `yaml:"maxRouters"`
+	MaxPercent float64 `yaml:"maxPercent"`
+}
+
+// RetainPolicy controls how long the routes of an unreachable server are kept.
+// They are removed once the server failed Failures times in a row and
+// GracePeriod seconds have passed since its last success. A negative
+// GracePeriod keeps them until the server recovers.
+type RetainPolicy struct {
+	Failures    int `yaml:"failures" json:"failures"`
+	GracePeriod int `yaml:"gracePeriod" json:"gracePeriod"`
+}
+
+// LeaderElection represents the leader election settings for running several replicas
+type LeaderElection struct {
+	Enabled       bool   `yaml:"enabled"`
+	ID            string `yaml:"id"`
+	LeaseDuration int    `yaml:"leaseDuration"`
+}
+
+// DefaultInstanceID is the relay instance ID used when none is configured
+const DefaultInstanceID = "default"
+
+// Publisher types
+const (
+	PublisherRedis  = "redis"
+	PublisherConsul = "consul"
+	PublisherEtcd   = "etcd"
+	PublisherNone   = "none"
+)
+
+// Publisher represents the key-value store the relayed configuration is written to
+type Publisher struct {
+	Type     string `yaml:"type"`
+	Address  string `yaml:"address"`
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// MaxTxnOps is the transaction size limit of etcd (--max-txn-ops)
+	MaxTxnOps int `yaml:"maxTxnOps"`
+	// SplitTransactions lets Consul and etcd publish runs too large for one
+	// transaction in several ones, giving up atomicity
+	SplitTransactions bool `yaml:"splitTransactions"`
+}
+
+// File represents the file provider output configuration
+type File struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
 }
 
 // Server represents a Traefik server configuration
 type Server struct {
-	Name               string            `yaml:"name"`
-	ApiAddress         string            `yaml:"apiAddress"`
-	ApiHost            string            `yaml:"apiHost"`
-	DestinationAddress string            `yaml:"destinationAddress"`
-	ForwardMiddlewares *bool             `yaml:"forwardMiddlewares"`
-	ForwardServices    *bool             `yaml:"forwardServices"`
-	EntryPoints        map[string]string `yaml:"entryPoints"`
+	Name                string            `yaml:"name"`
+	ApiAddress          string            `yaml:"apiAddress"`
+	ApiHost             string            `yaml:"apiHost"`
+	DestinationAddress  string            `yaml:"destinationAddress"`
+	Destinations        []Destination     `yaml:"destinations"`
+	ForwardMiddlewares  *bool             `yaml:"forwardMiddlewares"`
+	ForwardServices     *bool             `yaml:"forwardServices"`
+	EntryPoints         map[string]string `yaml:"entryPoints"`
+	EntryPointPorts     map[string]int    `yaml:"entryPointPorts"`
+	RetainOnError       *RetainPolicy     `yaml:"retainOnError"`
+	TLS                 *TLS              `yaml:"tls"`
+	RouterNameTemplate  string            `yaml:"routerNameTemplate"`
+	ServiceNameTemplate string            `yaml:"serviceNameTemplate"`
+	PriorityOffset      int64             `yaml:"priorityOffset"`
+	PriorityOverride    *int64            `yaml:"priorityOverride"`
+	Filters             Filters           `yaml:"filters"`
+	OptIn               *OptIn            `yaml:"optIn"`
+	OnlyEnabledRouters  *bool             `yaml:"onlyEnabledRouters"`
+	Rewrite             *Rewrite          `yaml:"rewrite"`
+	HealthCheck         *HealthCheck      `yaml:"healthCheck"`
+	LoadBalancer        *LoadBalancer     `yaml:"loadBalancer"`
+}
+
+// Destination is an address the traffic of a server is sent to. Weight
+// spreads the traffic between several destinations, zero means Traefik's default.
+type Destination struct {
+	Address string `yaml:"address"`
+	Weight  int    `yaml:"weight"`
+}
+
+// HealthCheck configures the health check of the services generated for a
+// server. HTTP services are checked when Path is set, TCP services when TCP is
+// set. Interval and Timeout are in seconds.
+type HealthCheck struct {
+	Path            string            `yaml:"path"`
+	Scheme          string            `yaml:"scheme"`
+	Method          string            `yaml:"method"`
+	Hostname        string            `yaml:"hostname"`
+	Port            int               `yaml:"port"`
+	Interval        int               `yaml:"interval"`
+	Timeout         int               `yaml:"timeout"`
+	Status          int               `yaml:"status"`
+	Headers         map[string]string `yaml:"headers"`
+	FollowRedirects *bool             `yaml:"followRedirects"`
+	TCP             bool              `yaml:"tcp"`
+	Send            string            `yaml:"send"`
+	Expect          string            `yaml:"expect"`
+}
+
+// LoadBalancer holds the options of the HTTP services generated for a
+// server. FlushInterval is in milliseconds, a negative value flushes
+// immediately after each write.
+type LoadBalancer struct {
+	PassHostHeader   *bool         `yaml:"passHostHeader"`
+	ServersTransport string        `yaml:"serversTransport"`
+	FlushInterval    int           `yaml:"flushInterval"`
+	StickyCookie     *StickyCookie `yaml:"stickyCookie"`
+}
+
+// StickyCookie keeps a client on the same destination with a cookie
+type StickyCookie struct {
+	Name     string `yaml:"name"`
+	Secure   bool   `yaml:"secure"`
+	HTTPOnly bool   `yaml:"httpOnly"`
+	SameSite string `yaml:"sameSite"`
+	MaxAge   int    `yaml:"maxAge"`
+}
+
+// TLS modes
+const (
+	TLSForward  = "forward"
+	TLSOverride = "override"
+	TLSDisabled = "disabled"
+)
+
+// TLS controls how the TLS settings of the routers of a server are relayed.
+// Mode forward publishes them as-is, override replaces the certResolver with
+// CertResolver and disabled drops them. Routers published on one of
+// EntryPoints get TLS even when the local router has none. Passthrough makes
+// TCP routers pass TLS connections through to the local instance.
+type TLS struct {
+	Mode         string   `yaml:"mode"`
+	CertResolver string   `yaml:"certResolver"`
+	EntryPoints  []string `yaml:"entryPoints"`
+	Passthrough  bool     `yaml:"passthrough"`
 }
 
 // LoadConfig loads the configuration from a YAML file
@@ -59,11 +207,101 @@ func validateConfig(config *Config) error {
 		config.RunEvery = 0
 	}
 
+	// Default instance ID, relays sharing a store must use distinct IDs
+	if config.InstanceID == "" {
+		config.InstanceID = DefaultInstanceID
+	}
+	if strings.ContainsAny(config.InstanceID, ":/ ") {
+		return fmt.Errorf("instanceId '%s' must not contain ':', '/' or spaces", config.InstanceID)
+	}
+
+	// Default to Redis, which is configured through REDIS_URL when no address is set
+	if config.Publisher.Type == "" {
+		config.Publisher.Type = PublisherRedis
+	}
+
+	// Validate publisher
+	if config.Publisher.MaxTxnOps < 0 {
+		return fmt.Errorf("publisher.maxTxnOps must not be negative")
+	}
+	switch config.Publisher.Type {
+	case PublisherRedis, PublisherNone:
+	case PublisherConsul, PublisherEtcd:
+		if config.Publisher.Address == "" {
+			return fmt.Errorf("publisher '%s' is missing address", config.Publisher.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported publisher type '%s'", config.Publisher.Type)
+	}
+
+	// Keep the routes of a failing server for a few runs by default
+	if config.RetainOnError.Failures <= 0 {
+		config.RetainOnError.Failures = 3
+	}
+
+	// Routers are suffixed with their server so names do not collide across servers
+	if config.RouterNameTemplate == "" {
+		config.RouterNameTemplate = naming.DefaultRouterTemplate
+	}
+	if err := validateRouterNameTemplate(config.RouterNameTemplate); err != nil {
+		return fmt.Errorf("invalid routerNameTemplate: %w", err)
+	}
+	if config.ServiceNameTemplate != "" {
+		if _, err := naming.Parse(config.ServiceNameTemplate); err != nil {
+			return fmt.Errorf("invalid serviceNameTemplate: %w", err)
+		}
+	}
+
+	// Compile router filters
+	if err := config.Filters.compile(); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
+	}
+	if err := config.OptIn.validate(); err != nil {
+		return fmt.Errorf("invalid optIn: %w", err)
+	}
+
+	// Validate deletion guard
+	if config.DeletionGuard.MaxRouters < 0 {
+		return fmt.Errorf("deletionGuard.maxRouters must not be negative")
+	}
+	if config.DeletionGuard.MaxPercent < 0 || config.DeletionGuard.MaxPercent > 100 {
+		return fmt.Errorf("deletionGuard.maxPercent must be between 0 and 100")
+	}
+
+	// Default lease duration in seconds
+	if config.LeaderElection.LeaseDuration <= 0 {
+		config.LeaderElection.LeaseDuration = 15
+	}
+
+	// Validate file output format
+	switch config.File.Format {
+	case "", "yaml", "yml", "toml":
+	default:
+		return fmt.Errorf("file output has unsupported format '%s'", config.File.Format)
+	}
+
+	names := make(map[string]bool)
 	for i, server := range config.Servers {
 		// Validate server name
 		if server.Name == "" {
 			return fmt.Errorf("server #%d is missing a name", i+1)
 		}
+		if names[server.Name] {
+			return fmt.Errorf("server name '%s' is used more than once", server.Name)
+		}
+		names[server.Name] = true
+
+		// Validate name template overrides
+		if server.RouterNameTemplate != "" {
+			if err := validateRouterNameTemplate(server.RouterNameTemplate); err != nil {
+				return fmt.Errorf("server '%s' has invalid routerNameTemplate: %w", server.Name, err)
+			}
+		}
+		if server.ServiceNameTemplate != "" {
+			if _, err := naming.Parse(server.ServiceNameTemplate); err != nil {
+				return fmt.Errorf("server '%s' has invalid serviceNameTemplate: %w", server.Name, err)
+			}
+		}
 
 		// Validate API address
 		if server.ApiAddress == "" {
@@ -73,12 +311,33 @@ func validateConfig(config *Config) error {
 			return fmt.Errorf("server '%s' has invalid apiAddress: %w", server.Name, err)
 		}
 
-		// Validate destination address
-		if server.DestinationAddress == "" {
-			return fmt.Errorf("server '%s' is missing destinationAddress", server.Name)
+		// Validate destination addresses
+		if server.DestinationAddress == "" && len(server.Destinations) == 0 {
+			return fmt.Errorf("server '%s' is missing destinationAddress or destinations", server.Name)
 		}
-		if _, err := url.Parse(server.DestinationAddress); err != nil {
-			return fmt.Errorf("server '%s' has invalid destinationAddress: %w", server.Name, err)
+		if server.DestinationAddress != "" && len(server.Destinations) > 0 {
+			return fmt.Errorf("server '%s' has both destinationAddress and destinations, use only one", server.Name)
+		}
+		if server.DestinationAddress != "" {
+			if _, err := url.Parse(server.DestinationAddress); err != nil {
+				return fmt.Errorf("server '%s' has invalid destinationAddress: %w", server.Name, err)
+			}
+		}
+		for j, destination := range server.Destinations {
+			if destination.Address == "" {
+				return fmt.Errorf("server '%s' destination #%d is missing address", server.Name, j+1)
+			}
+			if _, err := url.Parse(destination.Address); err != nil {
+				return fmt.Errorf("server '%s' destination #%d has invalid address: %w", server.Name, j+1, err)
+			}
+			if destination.Weight < 0 {
+				return fmt.Errorf("server '%s' destination #%d has negative weight", server.Name, j+1)
+			}
+		}
+
+		// Validate retain policy override
+		if server.RetainOnError != nil && server.RetainOnError.Failures <= 0 {
+			return fmt.Errorf("server '%s' has invalid retainOnError.failures: must be at least 1", server.Name)
 		}
 
 		// Set default entry points if not provided
@@ -88,11 +347,119 @@ func validateConfig(config *Config) error {
 			}
 			config.Servers[i].EntryPoints = server.EntryPoints
 		}
+
+		// Validate the published ports of local entrypoints
+		for localEP, port := range server.EntryPointPorts {
+			if !mapsEntryPoint(server.EntryPoints, localEP) {
+				return fmt.Errorf("server '%s' sets the port of unmapped entrypoint '%s'", server.Name, localEP)
+			}
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("server '%s' has invalid port %d for entrypoint '%s'", server.Name, port, localEP)
+			}
+		}
+
+		// Compile router filters, applied on top of the global ones
+		if err := config.Servers[i].Filters.compile(); err != nil {
+			return fmt.Errorf("server '%s' has invalid filters: %w", server.Name, err)
+		}
+		if server.OptIn != nil {
+			if err := server.OptIn.validate(); err != nil {
+				return fmt.Errorf("server '%s' has invalid optIn: %w", server.Name, err)
+			}
+		}
+
+		// Validate health check
+		if hc := server.HealthCheck; hc != nil {
+			if hc.Path == "" && !hc.TCP {
+				return fmt.Errorf("server '%s' has a healthCheck without path or tcp", server.Name)
+			}
+			if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+				return fmt.Errorf("server '%s' has invalid healthCheck.path: must start with '/'", server.Name)
+			}
+			if hc.Interval < 0 || hc.Timeout < 0 || hc.Port < 0 || hc.Port > 65535 {
+				return fmt.Errorf("server '%s' has invalid healthCheck interval, timeout or port", server.Name)
+			}
+			if hc.Status != 0 && (hc.Status < 100 || hc.Status > 599) {
+				return fmt.Errorf("server '%s' has invalid healthCheck.status %d", server.Name, hc.Status)
+			}
+		}
+
+		// Validate load balancer options
+		if lb := server.LoadBalancer; lb != nil {
+			if strings.ContainsAny(lb.ServersTransport, "/ ") {
+				return fmt.Errorf("server '%s' has invalid loadBalancer.serversTransport '%s'", server.Name, lb.ServersTransport)
+			}
+			if cookie := lb.StickyCookie; cookie != nil {
+				switch cookie.SameSite {
+				case "", "none", "lax", "strict":
+				default:
+					return fmt.Errorf("server '%s' has invalid loadBalancer.stickyCookie.sameSite '%s'", server.Name, cookie.SameSite)
+				}
+			}
+		}
+
+		// Compile rule rewrites
+		if server.Rewrite != nil {
+			if err := server.Rewrite.compile(); err != nil {
+				return fmt.Errorf("server '%s' has invalid rewrite: %w", server.Name, err)
+			}
+		}
+
+		// Validate TLS settings
+		if server.TLS != nil {
+			switch server.TLS.Mode {
+			case "":
+				server.TLS.Mode = TLSForward
+			case TLSForward, TLSDisabled:
+			case TLSOverride:
+				if server.TLS.CertResolver == "" {
+					return fmt.Errorf("server '%s' has tls.mode override but no tls.certResolver", server.Name)
+				}
+			default:
+				return fmt.Errorf("server '%s' has unsupported tls.mode '%s'", server.Name, server.TLS.Mode)
+			}
+			for _, ep := range server.TLS.EntryPoints {
+				if _, ok := server.EntryPoints[ep]; !ok {
+					return fmt.Errorf("server '%s' forces TLS on unmapped entrypoint '%s'", server.Name, ep)
+				}
+			}
+		}
+	}
+
+	// Validate failover groups and traffic splits once every server is known
+	if err := validateFailoverGroups(config); err != nil {
+		return err
+	}
+	if err := validateTrafficSplits(config); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// mapsEntryPoint reports whether a global entrypoint maps to localEP
+func mapsEntryPoint(entryPoints map[string]string, localEP string) bool {
+	for _, ep := range entryPoints {
+		if ep == localEP {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRouterNameTemplate checks that a router name template parses and
+// gives every router of a server its own name
+func validateRouterNameTemplate(text string) error {
+	tmpl, err := naming.Parse(text)
+	if err != nil {
+		return err
+	}
+	if !tmpl.DependsOn(func(f *naming.Fields, v string) { f.Router = v }) {
+		return fmt.Errorf("'%s' must include the router name", text)
+	}
+	return nil
+}
+
 // GetServerForwardMiddlewares determines if middlewares should be forwarded for a server
 func (s *Server) GetServerForwardMiddlewares(globalSetting bool) bool {
 	if s.ForwardMiddlewares != nil {
@@ -107,4 +474,80 @@ func (s *Server) GetServerForwardServices(globalSetting bool) bool {
 		return *s.ForwardServices
 	}
 	return globalSetting
-}
\ No newline at end of file
+}
+
+// GetDestinations returns the destinations of a server, either the
+// destinations list or the single destinationAddress
+func (s *Server) GetDestinations() []Destination {
+	if len(s.Destinations) > 0 {
+		return s.Destinations
+	}
+	return []Destination{{Address: s.DestinationAddress}}
+}
+
+// GetServerTLS returns the TLS settings of a server, forwarding by default
+func (s *Server) GetServerTLS() TLS {
+	if s.TLS != nil {
+		return *s.TLS
+	}
+	return TLS{Mode: TLSForward}
+}
+
+// GetServerPriority returns the priority to publish for a router of a server
+// with the given priority and rule. Traefik defaults a priority of 0 to the
+// length of the rule, which is what the offset is added to. ok is false when
+// the router keeps Traefik's default.
+func (s *Server) GetServerPriority(priority int64, rule string) (result int64, ok bool) {
+	if s.PriorityOverride != nil {
+		return *s.PriorityOverride, true
+	}
+	if priority == 0 {
+		if s.PriorityOffset == 0 {
+			return 0, false
+		}
+		priority = int64(len(rule))
+	}
+	return priority + s.PriorityOffset, true
+}
+
+// GetServerRouterNameTemplate determines the router name template for a server
+func (s *Server) GetServerRouterNameTemplate(globalSetting string) string {
+	if s.RouterNameTemplate != "" {
+		return s.RouterNameTemplate
+	}
+	return globalSetting
+}
+
+// GetServerServiceNameTemplate determines the service name template for a
+// server, empty when the default of each protocol applies
+func (s *Server) GetServerServiceNameTemplate(globalSetting string) string {
+	if s.ServiceNameTemplate != "" {
+		return s.ServiceNameTemplate
+	}
+	return globalSetting
+}
+
+// GetServerOptIn determines the opt-in settings for a server
+func (s *Server) GetServerOptIn(globalSetting OptIn) OptIn {
+	if s.OptIn != nil {
+		return *s.OptIn
+	}
+	return globalSetting
+}
+
+// GetServerOnlyEnabledRouters determines if only routers with status enabled
+// are relayed for a server, skipping routers with warnings
+func (s *Server) GetServerOnlyEnabledRouters(globalSetting bool) bool {
+	if s.OnlyEnabledRouters != nil {
+		return *s.OnlyEnabledRouters
+	}
+	return globalSetting
+}
+
+// GetServerRetainPolicy determines the retain policy for a server
+func (s *Server) GetServerRetainPolicy(globalSetting RetainPolicy) RetainPolicy {
+	if s.RetainOnError != nil {
+		return *s.RetainOnError
+	}
+	return globalSetting
+}
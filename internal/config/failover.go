@@ -0,0 +1,56 @@
+package config
+
+import "fmt"
+
+// FailoverGroup merges the HTTP routers with the same rule and entrypoints on
+// several servers into one router. Its service sends the traffic to the first
+// server of the list whose health check passes.
+type FailoverGroup struct {
+	Name    string   `yaml:"name"`
+	Servers []string `yaml:"servers"`
+}
+
+// validateFailoverGroups checks that every group ranks at least two known
+// servers and that no server is part of two groups
+func validateFailoverGroups(config *Config) error {
+	servers := make(map[string]*Server)
+	for i := range config.Servers {
+		servers[config.Servers[i].Name] = &config.Servers[i]
+	}
+
+	names := make(map[string]bool)
+	groupOf := make(map[string]string)
+	for i, group := range config.Failover {
+		if group.Name == "" {
+			return fmt.Errorf("failover group #%d is missing a name", i+1)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("failover group name '%s' is used more than once", group.Name)
+		}
+		names[group.Name] = true
+
+		if len(group.Servers) < 2 {
+			return fmt.Errorf("failover group '%s' needs at least two servers", group.Name)
+		}
+		for _, name := range group.Servers {
+			server, ok := servers[name]
+			if !ok {
+				return fmt.Errorf("failover group '%s' has unknown server '%s'", group.Name, name)
+			}
+			if other, ok := groupOf[name]; ok {
+				return fmt.Errorf("server '%s' is part of failover groups '%s' and '%s'", name, other, group.Name)
+			}
+			groupOf[name] = group.Name
+
+			// Traefik only fails over when the health check of a service fails
+			if server.HealthCheck == nil || server.HealthCheck.Path == "" {
+				return fmt.Errorf("server '%s' of failover group '%s' needs a healthCheck with a path", name, group.Name)
+			}
+			// Forwarded services of the local instance have no relayed health check
+			if server.GetServerForwardServices(config.ForwardServices) {
+				return fmt.Errorf("server '%s' of failover group '%s' cannot forward services, disable forwardServices", name, group.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filters select the routers that are relayed. A router is relayed when it
+// matches at least one Include rule, or Include is empty, and no Exclude rule.
+type Filters struct {
+	Include []FilterRule `yaml:"include"`
+	Exclude []FilterRule `yaml:"exclude"`
+}
+
+// FilterRule matches routers with regular expressions. Every expression set
+// must match; Host matches if any host of the rule matches and EntryPoint if
+// any local entrypoint of the router matches.
+type FilterRule struct {
+	Name       string `yaml:"name"`
+	Provider   string `yaml:"provider"`
+	Host       string `yaml:"host"`
+	Status     string `yaml:"status"`
+	EntryPoint string `yaml:"entryPoint"`
+
+	name, provider, host, status, entryPoint *regexp.Regexp
+}
+
+// FilterTarget holds the router data filters are matched against
+type FilterTarget struct {
+	Name        string
+	Provider    string
+	Status      string
+	Hosts       []string
+	EntryPoints []string
+	Middlewares []string
+}
+
+// compile compiles the regular expressions of all rules
+func (f *Filters) compile() error {
+	for i := range f.Include {
+		if err := f.Include[i].compile(); err != nil {
+			return fmt.Errorf("include #%d: %w", i+1, err)
+		}
+	}
+	for i := range f.Exclude {
+		if err := f.Exclude[i].compile(); err != nil {
+			return fmt.Errorf("exclude #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// compile compiles the regular expressions of a rule
+func (r *FilterRule) compile() error {
+	fields := []struct {
+		option  string
+		pattern string
+		target  **regexp.Regexp
+	}{
+		{"name", r.Name, &r.name},
+		{"provider", r.Provider, &r.provider},
+		{"host", r.Host, &r.host},
+		{"status", r.Status, &r.status},
+		{"entryPoint", r.EntryPoint, &r.entryPoint},
+	}
+
+	var set int
+	for _, field := range fields {
+		if field.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(field.pattern)
+		if err != nil {
+			return fmt.Errorf("invalid %s pattern: %w", field.option, err)
+		}
+		*field.target = re
+		set++
+	}
+	if set == 0 {
+		return fmt.Errorf("rule matches every router")
+	}
+	return nil
+}
+
+// Allows reports whether a router passes the filters
+func (f *Filters) Allows(target FilterTarget) bool {
+	for _, rule := range f.Exclude {
+		if rule.matches(target) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, rule := range f.Include {
+		if rule.matches(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether every pattern of a rule matches the router
+func (r *FilterRule) matches(target FilterTarget) bool {
+	if r.name != nil && !r.name.MatchString(target.Name) {
+		return false
+	}
+	if r.provider != nil && !r.provider.MatchString(target.Provider) {
+		return false
+	}
+	if r.status != nil && !r.status.MatchString(target.Status) {
+		return false
+	}
+	if r.host != nil && !matchAny(r.host, target.Hosts) {
+		return false
+	}
+	if r.entryPoint != nil && !matchAny(r.entryPoint, target.EntryPoints) {
+		return false
+	}
+	return true
+}
+
+// matchAny reports whether re matches any of values
+func matchAny(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptIn relays only the routers carrying a marker: the Middleware, a name
+// ending with NameSuffix or one of Providers
+type OptIn struct {
+	Enabled    bool     `yaml:"enabled"`
+	Middleware string   `yaml:"middleware"`
+	NameSuffix string   `yaml:"nameSuffix"`
+	Providers  []string `yaml:"providers"`
+}
+
+// validate checks that an enabled opt-in mode has a marker
+func (o *OptIn) validate() error {
+	if o.Enabled && o.Middleware == "" && o.NameSuffix == "" && len(o.Providers) == 0 {
+		return fmt.Errorf("no middleware, nameSuffix or providers set")
+	}
+	return nil
+}
+
+// Allows reports whether a router is relayed. name is the router name
+// without provider.
+func (o *OptIn) Allows(name, provider string, middlewares []string) bool {
+	if !o.Enabled {
+		return true
+	}
+	if o.NameSuffix != "" && strings.HasSuffix(name, o.NameSuffix) {
+		return true
+	}
+	for _, p := range o.Providers {
+		if strings.EqualFold(p, provider) {
+			return true
+		}
+	}
+	for _, middleware := range middlewares {
+		if o.IsMarker(middleware) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMarker reports whether a middleware is the opt-in marker, which is not
+// forwarded to the main instance
+func (o *OptIn) IsMarker(middleware string) bool {
+	return o.Enabled && o.Middleware != "" && strings.EqualFold(o.Middleware, middleware)
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite rewrites the rules of a server's routers before they are published,
+// e.g. to serve app.lan as app.example.com on the main instance
+type Rewrite struct {
+	Domains      []DomainMapping   `yaml:"domains"`
+	Replacements []RuleReplacement `yaml:"replacements"`
+	ExtraHosts   []string          `yaml:"extraHosts"`
+}
+
+// DomainMapping replaces the domain suffix From of hosts with To
+type DomainMapping struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// RuleReplacement replaces Pattern in the arguments of the given matchers,
+// the host matchers when none are set
+type RuleReplacement struct {
+	Pattern     string   `yaml:"pattern"`
+	Replacement string   `yaml:"replacement"`
+	Matchers    []string `yaml:"matchers"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rewrite and compiles its patterns
+func (r *Rewrite) compile() error {
+	for i, domain := range r.Domains {
+		if strings.Trim(domain.From, ".") == "" || strings.Trim(domain.To, ".") == "" {
+			return fmt.Errorf("domain mapping #%d needs from and to", i+1)
+		}
+	}
+	for i := range r.Replacements {
+		re, err := regexp.Compile(r.Replacements[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("replacement #%d has invalid pattern: %w", i+1, err)
+		}
+		r.Replacements[i].re = re
+	}
+	for _, host := range r.ExtraHosts {
+		if host == "" || strings.ContainsAny(host, "` ") {
+			return fmt.Errorf("invalid extra host '%s'", host)
+		}
+	}
+	return nil
+}
+
+// Regexp returns the compiled pattern
+func (r *RuleReplacement) Regexp() *regexp.Regexp {
+	return r.re
+}
+
+// AppliesTo reports whether the replacement applies to the arguments of a
+// matcher. hostMatcher is set for the matchers taking hosts.
+func (r *RuleReplacement) AppliesTo(matcher string, hostMatcher bool) bool {
+	if len(r.Matchers) == 0 {
+		return hostMatcher
+	}
+	for _, m := range r.Matchers {
+		if strings.EqualFold(m, matcher) {
+			return true
+		}
+	}
+	return false
+}
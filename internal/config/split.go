@@ -0,0 +1,122 @@
+package config
+
+import "fmt"
+
+// TrafficSplit spreads the traffic of a router found on several servers
+// between them by weight, e.g. to roll out a new host gradually
+type TrafficSplit struct {
+	Name    string        `yaml:"name" json:"name"`
+	Router  string        `yaml:"router" json:"router"`
+	Servers []SplitServer `yaml:"servers" json:"servers"`
+}
+
+// SplitServer is the share of the traffic of a split sent to a server
+type SplitServer struct {
+	Name   string `yaml:"name" json:"name"`
+	Weight int    `yaml:"weight" json:"weight"`
+}
+
+// GetTrafficSplit returns the traffic split with the given name
+func (c *Config) GetTrafficSplit(name string) (*TrafficSplit, bool) {
+	for i := range c.TrafficSplits {
+		if c.TrafficSplits[i].Name == name {
+			return &c.TrafficSplits[i], true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks the weights of a split, at least one server must receive traffic
+func (t *TrafficSplit) Validate(weights map[string]int) error {
+	total := 0
+	for server, weight := range weights {
+		if !t.HasServer(server) {
+			return fmt.Errorf("server '%s' is not part of traffic split '%s'", server, t.Name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("server '%s' has negative weight", server)
+		}
+		total += weight
+	}
+	if total == 0 {
+		return fmt.Errorf("traffic split '%s' needs at least one server with a positive weight", t.Name)
+	}
+	return nil
+}
+
+// HasServer reports whether server is part of the split
+func (t *TrafficSplit) HasServer(server string) bool {
+	for _, s := range t.Servers {
+		if s.Name == server {
+			return true
+		}
+	}
+	return false
+}
+
+// Weights returns the configured weight of every server of the split
+func (t *TrafficSplit) Weights() map[string]int {
+	weights := make(map[string]int, len(t.Servers))
+	for _, server := range t.Servers {
+		weights[server.Name] = server.Weight
+	}
+	return weights
+}
+
+// validateTrafficSplits checks that every split names a router and weighs at
+// least two known servers that are not part of a failover group
+func validateTrafficSplits(config *Config) error {
+	servers := make(map[string]bool)
+	for _, server := range config.Servers {
+		servers[server.Name] = true
+	}
+	failover := make(map[string]string)
+	for _, group := range config.Failover {
+		for _, server := range group.Servers {
+			failover[server] = group.Name
+		}
+	}
+
+	names := make(map[string]bool)
+	routers := make(map[string]string)
+	for i, split := range config.TrafficSplits {
+		if split.Name == "" {
+			return fmt.Errorf("traffic split #%d is missing a name", i+1)
+		}
+		if names[split.Name] {
+			return fmt.Errorf("traffic split name '%s' is used more than once", split.Name)
+		}
+		names[split.Name] = true
+
+		if split.Router == "" {
+			return fmt.Errorf("traffic split '%s' is missing a router", split.Name)
+		}
+		if other, ok := routers[split.Router]; ok {
+			return fmt.Errorf("router '%s' is split by traffic splits '%s' and '%s'", split.Router, other, split.Name)
+		}
+		routers[split.Router] = split.Name
+
+		if len(split.Servers) < 2 {
+			return fmt.Errorf("traffic split '%s' needs at least two servers", split.Name)
+		}
+		seen := make(map[string]bool)
+		for _, server := range split.Servers {
+			if !servers[server.Name] {
+				return fmt.Errorf("traffic split '%s' has unknown server '%s'", split.Name, server.Name)
+			}
+			if seen[server.Name] {
+				return fmt.Errorf("traffic split '%s' lists server '%s' more than once", split.Name, server.Name)
+			}
+			seen[server.Name] = true
+
+			// The failover service of a group cannot check the health of a weighted service
+			if group, ok := failover[server.Name]; ok {
+				return fmt.Errorf("server '%s' of traffic split '%s' is part of failover group '%s'", server.Name, split.Name, group)
+			}
+		}
+		if err := split.Validate(split.Weights()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
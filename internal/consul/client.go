@@ -0,0 +1,219 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hhftechnology/traefik-relay/internal/ownership"
+)
+
+// maxTxnOps is the maximum number of operations Consul accepts in one transaction
+const maxTxnOps = 64
+
+// errNotFound is returned when the requested key does not exist
+var errNotFound = errors.New("key not found")
+
+// Client is a client for the Consul KV HTTP API. The ownership of the
+// published keys is tracked by the embedded store.
+type Client struct {
+	*ownership.Store
+
+	httpClient *http.Client
+	baseURL    *url.URL
+	token      string
+
+	// Whether runs exceeding maxTxnOps may be published in several transactions
+	splitTransactions bool
+}
+
+// txnOp is a single operation of a Consul transaction
+type txnOp struct {
+	KV txnKVOp `json:"KV"`
+}
+
+// kvPair is a key-value pair returned by the Consul KV API
+type kvPair struct {
+	Key         string `json:"Key"`
+	Value       string `json:"Value"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+}
+
+// txnKVOp is a KV operation of a Consul transaction
+type txnKVOp struct {
+	Verb  string `json:"Verb"`
+	Key   string `json:"Key"`
+	Value string `json:"Value,omitempty"`
+	Index uint64 `json:"Index,omitempty"`
+}
+
+// NewClient creates a new Consul client and checks that the agent is reachable.
+// Keys are owned by the given relay instance. Runs too large for one
+// transaction fail unless splitTransactions is set.
+func NewClient(address, token, instance string, splitTransactions bool) (*Client, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Consul address: %w", err)
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		baseURL: baseURL,
+		token:   token,
+
+		splitTransactions: splitTransactions,
+	}
+	c.Store = ownership.NewStore(c, instance)
+
+	// Test the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.do(ctx, http.MethodGet, "v1/status/leader", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to Consul: %w", err)
+	}
+
+	return c, nil
+}
+
+// Name returns the name of the backend
+func (c *Client) Name() string {
+	return "consul"
+}
+
+// Close closes the Consul client
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// List returns the keys below prefix with the index they were last modified at
+func (c *Client) List(ctx context.Context, prefix string) ([]ownership.KeyValue, error) {
+	var pairs []kvPair
+	if err := c.do(ctx, http.MethodGet, "v1/kv/"+prefix+"?recurse=true", nil, &pairs); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result := make([]ownership.KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		value, err := base64.StdEncoding.DecodeString(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding value of '%s': %w", pair.Key, err)
+		}
+		result = append(result, ownership.KeyValue{Key: pair.Key, Value: string(value), Revision: pair.ModifyIndex})
+	}
+
+	return result, nil
+}
+
+// Commit applies ops in one transaction, guarded by a check of the modify
+// index of every key of checks
+func (c *Client) Commit(ctx context.Context, checks []ownership.KeyValue, ops []ownership.Op) error {
+	txn := make([]txnOp, 0, len(checks)+len(ops))
+	for _, check := range checks {
+		txn = append(txn, txnOp{KV: txnKVOp{Verb: "check-index", Key: check.Key, Index: check.Revision}})
+	}
+	for _, op := range ops {
+		if op.Delete {
+			txn = append(txn, txnOp{KV: txnKVOp{Verb: "delete", Key: op.Key}})
+			continue
+		}
+		txn = append(txn, txnOp{KV: txnKVOp{
+			Verb:  "set",
+			Key:   op.Key,
+			Value: base64.StdEncoding.EncodeToString([]byte(op.Value)),
+		}})
+	}
+
+	if len(txn) > maxTxnOps {
+		if !c.splitTransactions {
+			return fmt.Errorf("run needs %d operations, Consul accepts %d in one transaction (set publisher.splitTransactions to publish it non-atomically)",
+				len(txn), maxTxnOps)
+		}
+		log.Printf("Consul: publishing %d operations in several transactions, the update is not atomic", len(txn))
+	}
+
+	// Split the operations when allowed, the checks guard the first transaction
+	for start := 0; start < len(txn); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(txn) {
+			end = len(txn)
+		}
+
+		if err := c.do(ctx, http.MethodPut, "v1/txn", txn[start:end], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// do performs a request against the Consul API and unmarshals the response
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	reqURL := *c.baseURL
+	path, query, _ := strings.Cut(path, "?")
+	reqURL.Path = strings.TrimRight(reqURL.Path, "/") + "/" + path
+	reqURL.RawQuery = query
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode == http.StatusConflict {
+		// Consul rolls back transactions whose checks fail
+		return ownership.ErrConflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(data))
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("error decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
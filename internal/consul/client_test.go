@@ -0,0 +1,204 @@
+package consul
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/ownership"
+)
+
+// fakeConsul is an in-memory Consul KV store serving the endpoints used by the client
+type fakeConsul struct {
+	mu      sync.Mutex
+	values  map[string]string
+	indexes map[string]uint64
+	index   uint64
+	txns    int
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, *httptest.Server) {
+	f := &fakeConsul{values: make(map[string]string), indexes: make(map[string]uint64)}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// set stores a key as if written by another client. The caller must hold f.mu.
+func (f *fakeConsul) set(key, value string) {
+	f.index++
+	f.values[key] = value
+	f.indexes[key] = f.index
+}
+
+func (f *fakeConsul) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/status/leader":
+		fmt.Fprint(w, `"127.0.0.1:8300"`)
+	case r.URL.Path == "/v1/txn" && r.Method == http.MethodPut:
+		f.serveTxn(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.URL.Query().Has("recurse"):
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		var pairs []kvPair
+		for key, value := range f.values {
+			if strings.HasPrefix(key, prefix) {
+				pairs = append(pairs, kvPair{
+					Key:         key,
+					Value:       base64.StdEncoding.EncodeToString([]byte(value)),
+					ModifyIndex: f.indexes[key],
+				})
+			}
+		}
+		if len(pairs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsul) serveTxn(w http.ResponseWriter, r *http.Request) {
+	var ops []txnOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ops) > maxTxnOps {
+		http.Error(w, "too many operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	f.txns++
+
+	for _, op := range ops {
+		if op.KV.Verb == "check-index" && f.indexes[op.KV.Key] != op.KV.Index {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
+	for _, op := range ops {
+		switch op.KV.Verb {
+		case "set":
+			value, _ := base64.StdEncoding.DecodeString(op.KV.Value)
+			f.set(op.KV.Key, string(value))
+		case "delete":
+			delete(f.values, op.KV.Key)
+			delete(f.indexes, op.KV.Key)
+		}
+	}
+	fmt.Fprint(w, `{"Results":[]}`)
+}
+
+func TestList(t *testing.T) {
+	fake, server := newFakeConsul(t)
+	client, err := NewClient(server.URL, "", "office", false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// Consul answers 404 when nothing is found
+	if got, err := client.List(context.Background(), "traefik/"); err != nil || len(got) != 0 {
+		t.Fatalf("List() = %v, %v, want no keys", got, err)
+	}
+
+	fake.mu.Lock()
+	fake.set("traefik/http/routers/a/rule", "Host(`a`)")
+	fake.set("relay/office/owned", "[]")
+	fake.mu.Unlock()
+
+	got, err := client.List(context.Background(), "traefik/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []ownership.KeyValue{{Key: "traefik/http/routers/a/rule", Value: "Host(`a`)", Revision: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeConsul(t)
+	client, _ := NewClient(server.URL, "", "office", false)
+
+	fake.mu.Lock()
+	fake.set("relay/home/owned", "[]")
+	fake.set("traefik/http/routers/old/rule", "Host(`old`)")
+	fake.mu.Unlock()
+
+	check := ownership.KeyValue{Key: "relay/home/owned", Revision: 1}
+	ops := []ownership.Op{
+		{Key: "traefik/http/routers/new/rule", Value: "Host(`new`)"},
+		{Key: "traefik/http/routers/old/rule", Delete: true},
+	}
+	if err := client.Commit(ctx, []ownership.KeyValue{check}, ops); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	want := map[string]string{"relay/home/owned": "[]", "traefik/http/routers/new/rule": "Host(`new`)"}
+	if !reflect.DeepEqual(fake.values, want) {
+		t.Errorf("values = %v, want %v", fake.values, want)
+	}
+	// Another client changes the checked key
+	fake.set("relay/home/owned", `["traefik/http/routers/new/rule"]`)
+	fake.mu.Unlock()
+
+	err := client.Commit(ctx, []ownership.KeyValue{check}, []ownership.Op{{Key: "traefik/http/routers/new/rule", Delete: true}})
+	if !errors.Is(err, ownership.ErrConflict) {
+		t.Errorf("Commit() error = %v, want ErrConflict", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.values["traefik/http/routers/new/rule"]; !ok {
+		t.Error("transaction with a failed check was applied")
+	}
+}
+
+func TestCommitLargeRuns(t *testing.T) {
+	ops := make([]ownership.Op, 0, maxTxnOps+1)
+	for i := 0; i <= maxTxnOps; i++ {
+		ops = append(ops, ownership.Op{Key: fmt.Sprintf("traefik/http/routers/r%d/rule", i), Value: "Host(`a`)"})
+	}
+
+	tests := []struct {
+		name              string
+		splitTransactions bool
+		wantErr           bool
+		wantTxns          int
+	}{
+		{name: "rejected", wantErr: true},
+		{name: "split", splitTransactions: true, wantTxns: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, server := newFakeConsul(t)
+			client, _ := NewClient(server.URL, "", "office", tt.splitTransactions)
+
+			err := client.Commit(context.Background(), nil, ops)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.txns != tt.wantTxns {
+				t.Errorf("transactions = %d, want %d", fake.txns, tt.wantTxns)
+			}
+		})
+	}
+}
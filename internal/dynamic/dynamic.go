@@ -0,0 +1,232 @@
+package dynamic
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rootKey is the prefix every relayed key starts with
+const rootKey = "traefik"
+
+// canonicalNames maps the lowercase key segments used in the KV layout to the
+// option names used in Traefik's file and HTTP providers
+var canonicalNames = map[string]string{
+	"certresolver":       "certResolver",
+	"entrypoints":        "entryPoints",
+	"flushinterval":      "flushInterval",
+	"followredirects":    "followRedirects",
+	"healthcheck":        "healthCheck",
+	"httponly":           "httpOnly",
+	"loadbalancer":       "loadBalancer",
+	"maxage":             "maxAge",
+	"passhostheader":     "passHostHeader",
+	"responseforwarding": "responseForwarding",
+	"samesite":           "sameSite",
+	"serverstransport":   "serversTransport",
+}
+
+// booleanOptions are the options whose values are encoded as booleans, the
+// HTTP provider rejects them as strings. Paths start below the protocol and "*"
+// matches any segment, so that options of other objects with the same name,
+// e.g. the port of a redirectScheme middleware, keep their value.
+var booleanOptions = []string{
+	"routers/*/tls/passthrough",
+	"services/*/loadBalancer/healthCheck/followRedirects",
+	"services/*/loadBalancer/passHostHeader",
+	"services/*/loadBalancer/sticky/cookie/httpOnly",
+	"services/*/loadBalancer/sticky/cookie/secure",
+}
+
+// integerOptions are the options whose values are encoded as integers
+var integerOptions = []string{
+	"routers/*/priority",
+	"services/*/loadBalancer/healthCheck/port",
+	"services/*/loadBalancer/healthCheck/status",
+	"services/*/loadBalancer/servers/*/weight",
+	"services/*/loadBalancer/sticky/cookie/maxAge",
+	"services/*/weighted/services/*/weight",
+}
+
+// listOptions are the options holding lists, stored with one key per index
+// in the KV layout
+var listOptions = map[string]bool{
+	"domains":     true,
+	"entryPoints": true,
+	"middlewares": true,
+	"sans":        true,
+	"servers":     true,
+	"services":    true,
+}
+
+// objectFlags are options the KV layout enables with "true" while the file and
+// HTTP providers expect an empty object
+var objectFlags = []string{
+	"routers/*/tls",
+	"services/*/failover/healthCheck",
+	"services/*/loadBalancer/healthCheck",
+	"services/*/loadBalancer/sticky/cookie",
+}
+
+// Build converts flat "traefik/..." entries into a Traefik dynamic configuration
+// tree that can be encoded as JSON, YAML or TOML
+func Build(entries map[string]string) map[string]interface{} {
+	root := make(map[string]interface{})
+
+	// Sort keys so that conflicting entries are always resolved the same way
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		segments := strings.Split(key, "/")
+		if len(segments) < 2 || segments[0] != rootKey {
+			continue
+		}
+		setValue(root, canonicalize(segments[1:]), entries[key])
+	}
+
+	for _, sections := range root {
+		sections, ok := sections.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, objects := range sections {
+			objects, ok := objects.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for name, object := range objects {
+				objects[name] = compact(object)
+			}
+		}
+	}
+
+	return root
+}
+
+// canonicalize renames option segments, leaving the protocol, section and
+// object name untouched
+func canonicalize(segments []string) []string {
+	result := make([]string, len(segments))
+	for i, segment := range segments {
+		// Segments 0-2 are the protocol, section and object name (e.g. http/routers/whoami)
+		if i > 2 {
+			if name, ok := canonicalNames[segment]; ok {
+				segment = name
+			}
+		}
+		result[i] = segment
+	}
+	return result
+}
+
+// setValue stores value at the given path, creating intermediate maps.
+// When a key is both a value and a parent of other keys, the parent wins.
+func setValue(node map[string]interface{}, path []string, value string) {
+	for _, segment := range path[:len(path)-1] {
+		child, ok := node[segment].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[segment] = child
+		}
+		node = child
+	}
+
+	last := path[len(path)-1]
+	if _, isMap := node[last].(map[string]interface{}); !isMap {
+		// The protocol is left out, options are the same for HTTP, TCP and UDP
+		option := path[1:]
+		if value == "true" && matchAny(objectFlags, option) {
+			node[last] = make(map[string]interface{})
+			return
+		}
+		node[last] = typedValue(option, value)
+	}
+}
+
+// typedValue converts the value of an option to the type Traefik expects,
+// keeping it as a string when it does not parse
+func typedValue(option []string, value string) interface{} {
+	if matchAny(booleanOptions, option) {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	if matchAny(integerOptions, option) {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return value
+}
+
+// matchAny reports whether path matches one of the patterns, where "*"
+// matches any segment
+func matchAny(patterns []string, path []string) bool {
+	for _, pattern := range patterns {
+		segments := strings.Split(pattern, "/")
+		if len(segments) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range segments {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// compact turns the list options of an object, maps whose keys are all
+// indexes, into slices ordered by index. Object names are never compacted, a
+// router named "1" stays a router.
+func compact(node interface{}) interface{} {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+
+	for key, child := range m {
+		child = compact(child)
+		if listOptions[key] {
+			child = toList(child)
+		}
+		m[key] = child
+	}
+	return m
+}
+
+// toList turns a map whose keys are all indexes into a slice ordered by index
+func toList(node interface{}) interface{} {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+
+	indexes := make([]int, 0, len(m))
+	for key := range m {
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 {
+			return m
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return m
+	}
+	sort.Ints(indexes)
+
+	list := make([]interface{}, 0, len(indexes))
+	for _, index := range indexes {
+		list = append(list, m[strconv.Itoa(index)])
+	}
+	return list
+}
@@ -0,0 +1,169 @@
+package dynamic
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildValueTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		want    string
+	}{
+		{
+			name: "router priority",
+			entries: map[string]string{
+				"traefik/http/routers/whoami/priority": "10",
+			},
+			want: `{"http":{"routers":{"whoami":{"priority":10}}}}`,
+		},
+		{
+			name: "health check port and status",
+			entries: map[string]string{
+				"traefik/http/services/whoami/loadbalancer/healthcheck/port":            "8080",
+				"traefik/http/services/whoami/loadbalancer/healthcheck/status":          "204",
+				"traefik/http/services/whoami/loadbalancer/healthcheck/followredirects": "false",
+			},
+			want: `{"http":{"services":{"whoami":{"loadBalancer":{"healthCheck":{"followRedirects":false,"port":8080,"status":204}}}}}}`,
+		},
+		{
+			name: "middleware options keep their value",
+			entries: map[string]string{
+				"traefik/http/middlewares/https/redirectscheme/port":   "443",
+				"traefik/http/middlewares/https/redirectscheme/scheme": "https",
+				"traefik/http/middlewares/errors/errors/status/0":      "500",
+				"traefik/http/middlewares/auth/headers/secure":         "true",
+			},
+			want: `{"http":{"middlewares":{"auth":{"headers":{"secure":"true"}},"errors":{"errors":{"status":{"0":"500"}}},"https":{"redirectscheme":{"port":"443","scheme":"https"}}}}}`,
+		},
+		{
+			name: "server and weighted service weights",
+			entries: map[string]string{
+				"traefik/http/services/whoami/loadbalancer/servers/0/url":    "http://10.0.0.1",
+				"traefik/http/services/whoami/loadbalancer/servers/0/weight": "3",
+				"traefik/http/services/split/weighted/services/0/name":       "whoami",
+				"traefik/http/services/split/weighted/services/0/weight":     "90",
+			},
+			want: `{"http":{"services":{"split":{"weighted":{"services":[{"name":"whoami","weight":90}]}},"whoami":{"loadBalancer":{"servers":[{"url":"http://10.0.0.1","weight":3}]}}}}}`,
+		},
+		{
+			name: "sticky cookie",
+			entries: map[string]string{
+				"traefik/http/services/whoami/loadbalancer/passhostheader":                   "true",
+				"traefik/http/services/whoami/loadbalancer/sticky/cookie/secure":             "true",
+				"traefik/http/services/whoami/loadbalancer/sticky/cookie/httponly":           "yes",
+				"traefik/http/services/whoami/loadbalancer/sticky/cookie/maxage":             "3600",
+				"traefik/http/services/whoami/loadbalancer/sticky/cookie/samesite":           "lax",
+				"traefik/http/services/whoami/loadbalancer/serverstransport":                 "internal",
+				"traefik/http/services/whoami/loadbalancer/responseforwarding/flushinterval": "100ms",
+			},
+			want: `{"http":{"services":{"whoami":{"loadBalancer":{"passHostHeader":true,"responseForwarding":{"flushInterval":"100ms"},"serversTransport":"internal","sticky":{"cookie":{"httpOnly":"yes","maxAge":3600,"sameSite":"lax","secure":true}}}}}}}`,
+		},
+		{
+			name: "object flags",
+			entries: map[string]string{
+				"traefik/http/services/whoami/loadbalancer/sticky/cookie":          "true",
+				"traefik/http/services/fo/failover/healthcheck":                    "true",
+				"traefik/http/services/fo/failover/service":                        "whoami",
+				"traefik/http/services/other/loadbalancer/healthcheck/headers/tls": "true",
+			},
+			want: `{"http":{"services":{"fo":{"failover":{"healthCheck":{},"service":"whoami"}},"other":{"loadBalancer":{"healthCheck":{"headers":{"tls":"true"}}}},"whoami":{"loadBalancer":{"sticky":{"cookie":{}}}}}}}`,
+		},
+		{
+			name: "TCP health check port",
+			entries: map[string]string{
+				"traefik/tcp/services/db/loadbalancer/healthcheck/port": "5432",
+			},
+			want: `{"tcp":{"services":{"db":{"loadBalancer":{"healthCheck":{"port":5432}}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(Build(tt.entries))
+			if err != nil {
+				t.Fatalf("error encoding configuration: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Build() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCompact(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries map[string]string
+		want    string
+	}{
+		{
+			name: "lists ordered by index",
+			entries: map[string]string{
+				"traefik/http/routers/whoami/entrypoints/1": "websecure",
+				"traefik/http/routers/whoami/entrypoints/0": "web",
+				"traefik/http/routers/whoami/middlewares/0": "auth@file",
+			},
+			want: `{"http":{"routers":{"whoami":{"entryPoints":["web","websecure"],"middlewares":["auth@file"]}}}}`,
+		},
+		{
+			name: "more than ten items",
+			entries: map[string]string{
+				"traefik/http/routers/r/entrypoints/10": "k",
+				"traefik/http/routers/r/entrypoints/2":  "c",
+				"traefik/http/routers/r/entrypoints/0":  "a",
+			},
+			want: `{"http":{"routers":{"r":{"entryPoints":["a","c","k"]}}}}`,
+		},
+		{
+			name: "numeric object names",
+			entries: map[string]string{
+				"traefik/http/routers/0/rule":              "Host(`a`)",
+				"traefik/http/routers/1/rule":              "Host(`b`)",
+				"traefik/http/services/0/failover/service": "x",
+			},
+			want: `{"http":{"routers":{"0":{"rule":"Host(` + "`a`" + `)"},"1":{"rule":"Host(` + "`b`" + `)"}},"services":{"0":{"failover":{"service":"x"}}}}}`,
+		},
+		{
+			name: "numeric keys of other options",
+			entries: map[string]string{
+				"traefik/http/services/whoami/loadbalancer/healthcheck/headers/0": "x",
+			},
+			want: `{"http":{"services":{"whoami":{"loadBalancer":{"healthCheck":{"headers":{"0":"x"}}}}}}}`,
+		},
+		{
+			name: "list option with names",
+			entries: map[string]string{
+				"traefik/http/services/split/weighted/services/a/weight": "1",
+			},
+			want: `{"http":{"services":{"split":{"weighted":{"services":{"a":{"weight":1}}}}}}}`,
+		},
+		{
+			name: "keys outside of the root",
+			entries: map[string]string{
+				"relay/default/servers/a":        "{}",
+				"traefik/tcp/routers/db/rule":    "HostSNI(`*`)",
+				"traefik/tcp/routers/db/service": "db",
+			},
+			want: `{"tcp":{"routers":{"db":{"rule":"HostSNI(` + "`*`" + `)","service":"db"}}}}`,
+		},
+		{
+			name:    "no entries",
+			entries: map[string]string{},
+			want:    `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(Build(tt.entries))
+			if err != nil {
+				t.Fatalf("error encoding configuration: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Build() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hhftechnology/traefik-relay/internal/ownership"
+)
+
+// DefaultMaxTxnOps is the default maximum number of operations etcd accepts
+// in one transaction, set by its --max-txn-ops flag
+const DefaultMaxTxnOps = 128
+
+// errUnauthenticated is returned when etcd rejected the auth token
+var errUnauthenticated = errors.New("etcd rejected the auth token")
+
+// Client is a client for the etcd v3 JSON gateway. The ownership of the
+// published keys is tracked by the embedded store.
+type Client struct {
+	*ownership.Store
+
+	httpClient *http.Client
+	baseURL    *url.URL
+	username   string
+	password   string
+	authToken  string
+	maxTxnOps  int
+
+	// Whether runs exceeding maxTxnOps may be published in several transactions
+	splitTransactions bool
+}
+
+// requestOp is a single operation of an etcd transaction
+type requestOp struct {
+	RequestPut         *putRequest         `json:"requestPut,omitempty"`
+	RequestDeleteRange *deleteRangeRequest `json:"requestDeleteRange,omitempty"`
+}
+
+// putRequest stores a key
+type putRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// deleteRangeRequest deletes a key
+type deleteRangeRequest struct {
+	Key string `json:"key"`
+}
+
+// rangeRequest reads a key, or all keys in [key, rangeEnd)
+type rangeRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end,omitempty"`
+}
+
+// rangeResponse holds the key-value pairs returned by a range request
+type rangeResponse struct {
+	Kvs []keyValue `json:"kvs"`
+}
+
+// keyValue is a key-value pair returned by a range request, base64-encoded
+type keyValue struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+// compare is a comparison of a transaction on the revision a key was last modified at
+type compare struct {
+	Key         string `json:"key"`
+	Target      string `json:"target"`
+	Result      string `json:"result"`
+	ModRevision string `json:"mod_revision"`
+}
+
+// txnRequest is an etcd transaction, applied when all comparisons succeed
+type txnRequest struct {
+	Compare []compare   `json:"compare,omitempty"`
+	Success []requestOp `json:"success"`
+}
+
+// txnResponse reports whether the comparisons of a transaction succeeded
+type txnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// NewClient creates a new etcd client and checks that the cluster is reachable.
+// When username is set, the client authenticates on its first request. Keys are
+// owned by the given relay instance. Runs of more than maxTxnOps operations
+// fail unless splitTransactions is set.
+func NewClient(address, username, password, instance string, maxTxnOps int, splitTransactions bool) (*Client, error) {
+	if maxTxnOps <= 0 {
+		maxTxnOps = DefaultMaxTxnOps
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid etcd address: %w", err)
+	}
+
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		baseURL:   baseURL,
+		username:  username,
+		password:  password,
+		maxTxnOps: maxTxnOps,
+
+		splitTransactions: splitTransactions,
+	}
+	c.Store = ownership.NewStore(c, instance)
+
+	// Test the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.do(ctx, "version", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd: %w", err)
+	}
+
+	return c, nil
+}
+
+// Name returns the name of the backend
+func (c *Client) Name() string {
+	return "etcd"
+}
+
+// Close closes the etcd client
+func (c *Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// List returns the keys below prefix with the revision they were last modified at
+func (c *Client) List(ctx context.Context, prefix string) ([]ownership.KeyValue, error) {
+	// '0' is the byte following '/', so the range covers every key below prefix
+	req := rangeRequest{
+		Key:      encode(prefix),
+		RangeEnd: encode(strings.TrimSuffix(prefix, "/") + "0"),
+	}
+	var resp rangeResponse
+	if err := c.call(ctx, "v3/kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+
+	result := make([]ownership.KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding key: %w", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding value of '%s': %w", key, err)
+		}
+		revision, err := strconv.ParseUint(kv.ModRevision, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding revision of '%s': %w", key, err)
+		}
+		result = append(result, ownership.KeyValue{Key: string(key), Value: string(value), Revision: revision})
+	}
+
+	return result, nil
+}
+
+// Commit applies ops in one transaction, guarded by a comparison of the
+// revision of every key of checks
+func (c *Client) Commit(ctx context.Context, checks []ownership.KeyValue, ops []ownership.Op) error {
+	compares := make([]compare, 0, len(checks))
+	for _, check := range checks {
+		compares = append(compares, compare{
+			Key:         encode(check.Key),
+			Target:      "MOD",
+			Result:      "EQUAL",
+			ModRevision: strconv.FormatUint(check.Revision, 10),
+		})
+	}
+
+	txn := make([]requestOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Delete {
+			txn = append(txn, requestOp{RequestDeleteRange: &deleteRangeRequest{Key: encode(op.Key)}})
+			continue
+		}
+		txn = append(txn, requestOp{RequestPut: &putRequest{Key: encode(op.Key), Value: encode(op.Value)}})
+	}
+
+	if len(txn) > c.maxTxnOps {
+		if !c.splitTransactions {
+			return fmt.Errorf("run needs %d operations, etcd accepts %d in one transaction (raise publisher.maxTxnOps or set publisher.splitTransactions)",
+				len(txn), c.maxTxnOps)
+		}
+		log.Printf("etcd: publishing %d operations in several transactions, the update is not atomic", len(txn))
+	}
+
+	// Split the operations when allowed, the comparisons guard the first transaction
+	for start := 0; start < len(txn); start += c.maxTxnOps {
+		end := start + c.maxTxnOps
+		if end > len(txn) {
+			end = len(txn)
+		}
+
+		req := txnRequest{Success: txn[start:end]}
+		if start == 0 {
+			req.Compare = compares
+		}
+
+		var resp txnResponse
+		if err := c.call(ctx, "v3/kv/txn", req, &resp); err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			return ownership.ErrConflict
+		}
+	}
+
+	return nil
+}
+
+// authenticate fetches an auth token when credentials are configured and no
+// token is cached yet
+func (c *Client) authenticate(ctx context.Context) error {
+	if c.username == "" || c.authToken != "" {
+		return nil
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	body := map[string]string{"name": c.username, "password": c.password}
+
+	if err := c.do(ctx, "v3/auth/authenticate", body, &resp); err != nil {
+		return fmt.Errorf("etcd authentication failed: %w", err)
+	}
+	c.authToken = resp.Token
+
+	return nil
+}
+
+// call performs an authenticated request. The token is cached, since password
+// authentication is expensive for etcd, and renewed once when it was rejected.
+func (c *Client) call(ctx context.Context, path string, body, result interface{}) error {
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+
+	err := c.do(ctx, path, body, result)
+	if !errors.Is(err, errUnauthenticated) || c.username == "" {
+		return err
+	}
+
+	c.authToken = ""
+	if err := c.authenticate(ctx); err != nil {
+		return err
+	}
+	return c.do(ctx, path, body, result)
+}
+
+// do performs a request against the etcd gateway and unmarshals the response.
+// Requests with a body are sent as POST, others as GET.
+func (c *Client) do(ctx context.Context, path string, body, result interface{}) error {
+	reqURL := *c.baseURL
+	reqURL.Path = strings.TrimRight(reqURL.Path, "/") + "/" + path
+
+	method := http.MethodGet
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+		method = http.MethodPost
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		// Expired or revoked tokens are rejected as unauthenticated
+		if resp.StatusCode == http.StatusUnauthorized || strings.Contains(string(data), "invalid auth token") {
+			return fmt.Errorf("%w: %s", errUnauthenticated, string(data))
+		}
+		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(data))
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("error decoding response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// encode base64-encodes keys and values as required by the JSON gateway
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,244 @@
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/ownership"
+)
+
+// fakeEtcd is an in-memory etcd serving the JSON gateway endpoints used by the client
+type fakeEtcd struct {
+	mu        sync.Mutex
+	values    map[string]string
+	revisions map[string]int64
+	revision  int64
+	txns      int
+
+	// token is required in the Authorization header once set
+	token string
+	// auths counts the authentications
+	auths int
+}
+
+func newFakeEtcd(t *testing.T) (*fakeEtcd, *httptest.Server) {
+	f := &fakeEtcd{values: make(map[string]string), revisions: make(map[string]int64)}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// set stores a key as if written by another client. The caller must hold f.mu.
+func (f *fakeEtcd) set(key, value string) {
+	f.revision++
+	f.values[key] = value
+	f.revisions[key] = f.revision
+}
+
+// decode decodes a base64-encoded key or value of the JSON gateway
+func decode(s string) string {
+	data, _ := base64.StdEncoding.DecodeString(s)
+	return string(data)
+}
+
+func (f *fakeEtcd) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/version" {
+		fmt.Fprint(w, `{"etcdserver":"3.5.0"}`)
+		return
+	}
+	if r.URL.Path == "/v3/auth/authenticate" {
+		f.auths++
+		fmt.Fprintf(w, `{"token":%q}`, f.token)
+		return
+	}
+	if f.token != "" && r.Header.Get("Authorization") != f.token {
+		http.Error(w, "invalid auth token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req rangeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		key, end := decode(req.Key), decode(req.RangeEnd)
+
+		resp := rangeResponse{Kvs: []keyValue{}}
+		for k, value := range f.values {
+			if k == key || (end != "" && k >= key && k < end) {
+				resp.Kvs = append(resp.Kvs, keyValue{
+					Key:         base64.StdEncoding.EncodeToString([]byte(k)),
+					Value:       base64.StdEncoding.EncodeToString([]byte(value)),
+					ModRevision: strconv.FormatInt(f.revisions[k], 10),
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "/v3/kv/txn":
+		var req txnRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.txns++
+
+		for _, cmp := range req.Compare {
+			if strconv.FormatInt(f.revisions[decode(cmp.Key)], 10) != cmp.ModRevision {
+				fmt.Fprint(w, `{"succeeded":false}`)
+				return
+			}
+		}
+		for _, op := range req.Success {
+			if op.RequestPut != nil {
+				f.set(decode(op.RequestPut.Key), decode(op.RequestPut.Value))
+			}
+			if op.RequestDeleteRange != nil {
+				delete(f.values, decode(op.RequestDeleteRange.Key))
+				delete(f.revisions, decode(op.RequestDeleteRange.Key))
+			}
+		}
+		fmt.Fprint(w, `{"succeeded":true}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestList(t *testing.T) {
+	fake, server := newFakeEtcd(t)
+	client, err := NewClient(server.URL, "", "", "office", 0, false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.set("traefik/http/routers/a/rule", "Host(`a`)")
+	fake.set("traefik0", "outside of the prefix")
+	fake.set("relay/office/owned", "[]")
+	fake.mu.Unlock()
+
+	got, err := client.List(context.Background(), "traefik/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []ownership.KeyValue{{Key: "traefik/http/routers/a/rule", Value: "Host(`a`)", Revision: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeEtcd(t)
+	client, _ := NewClient(server.URL, "", "", "office", 0, false)
+
+	fake.mu.Lock()
+	fake.set("relay/home/owned", "[]")
+	fake.set("traefik/http/routers/old/rule", "Host(`old`)")
+	fake.mu.Unlock()
+
+	check := ownership.KeyValue{Key: "relay/home/owned", Revision: 1}
+	ops := []ownership.Op{
+		{Key: "traefik/http/routers/new/rule", Value: "Host(`new`)"},
+		{Key: "traefik/http/routers/old/rule", Delete: true},
+	}
+	if err := client.Commit(ctx, []ownership.KeyValue{check}, ops); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	want := map[string]string{"relay/home/owned": "[]", "traefik/http/routers/new/rule": "Host(`new`)"}
+	if !reflect.DeepEqual(fake.values, want) {
+		t.Errorf("values = %v, want %v", fake.values, want)
+	}
+	// Another client changes the compared key
+	fake.set("relay/home/owned", `["traefik/http/routers/new/rule"]`)
+	fake.mu.Unlock()
+
+	err := client.Commit(ctx, []ownership.KeyValue{check}, []ownership.Op{{Key: "traefik/http/routers/new/rule", Delete: true}})
+	if !errors.Is(err, ownership.ErrConflict) {
+		t.Errorf("Commit() error = %v, want ErrConflict", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if _, ok := fake.values["traefik/http/routers/new/rule"]; !ok {
+		t.Error("transaction with a failed comparison was applied")
+	}
+}
+
+func TestCommitLargeRuns(t *testing.T) {
+	ops := make([]ownership.Op, 0, 10)
+	for i := 0; i < 10; i++ {
+		ops = append(ops, ownership.Op{Key: fmt.Sprintf("traefik/http/routers/r%d/rule", i), Value: "Host(`a`)"})
+	}
+
+	tests := []struct {
+		name              string
+		maxTxnOps         int
+		splitTransactions bool
+		wantErr           bool
+		wantTxns          int
+	}{
+		{name: "within limit", maxTxnOps: 10, wantTxns: 1},
+		{name: "rejected", maxTxnOps: 9, wantErr: true},
+		{name: "split", maxTxnOps: 4, splitTransactions: true, wantTxns: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, server := newFakeEtcd(t)
+			client, _ := NewClient(server.URL, "", "", "office", tt.maxTxnOps, tt.splitTransactions)
+
+			err := client.Commit(context.Background(), nil, ops)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Commit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.txns != tt.wantTxns {
+				t.Errorf("transactions = %d, want %d", fake.txns, tt.wantTxns)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	ctx := context.Background()
+	fake, server := newFakeEtcd(t)
+	fake.token = "first-token"
+	client, err := NewClient(server.URL, "relay", "password", "office", 0, false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	// The token is fetched once and reused
+	for i := 0; i < 3; i++ {
+		if _, err := client.List(ctx, "traefik/"); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+	}
+	fake.mu.Lock()
+	if fake.auths != 1 {
+		t.Errorf("authentications = %d, want 1", fake.auths)
+	}
+	// The token expires
+	fake.token = "second-token"
+	fake.mu.Unlock()
+
+	if _, err := client.List(ctx, "traefik/"); err != nil {
+		t.Fatalf("List() with an expired token error = %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.auths != 2 {
+		t.Errorf("authentications = %d, want 2", fake.auths)
+	}
+}
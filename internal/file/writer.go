@@ -0,0 +1,174 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hhftechnology/traefik-relay/internal/dynamic"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported output formats
+const (
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Writer renders entries into a Traefik dynamic configuration file
+type Writer struct {
+	path   string
+	format string
+}
+
+// NewWriter creates a new file writer. When format is empty it is derived from
+// the file extension, defaulting to YAML.
+func NewWriter(path, format string) (*Writer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+
+	format = strings.ToLower(format)
+	if format == "" {
+		format = FormatYAML
+		if strings.EqualFold(filepath.Ext(path), ".toml") {
+			format = FormatTOML
+		}
+	}
+	if format == "yml" {
+		format = FormatYAML
+	}
+	if format != FormatYAML && format != FormatTOML {
+		return nil, fmt.Errorf("unsupported file format '%s'", format)
+	}
+
+	return &Writer{path: path, format: format}, nil
+}
+
+// Name returns the name of the backend
+func (w *Writer) Name() string {
+	return "file"
+}
+
+// Close is a no-op, the file is not kept open between writes
+func (w *Writer) Close() error {
+	return nil
+}
+
+// Load returns no entries, the file is rewritten as a whole on each run so
+// there are no stale keys to clean up
+func (w *Writer) Load(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+// PublishesSnapshots reports that the file is rendered on every run, so that
+// it is emptied when the last route disappears
+func (w *Writer) PublishesSnapshots() bool {
+	return true
+}
+
+// Publish replaces the file with the complete set of entries when they differ
+// from its content, including when there are no entries left
+func (w *Writer) Publish(ctx context.Context, entries, updated map[string]string, removed []string) error {
+	written, err := w.Write(entries)
+	if err != nil {
+		return err
+	}
+	if written {
+		log.Printf("Configuration file '%s' updated", w.path)
+	}
+	return nil
+}
+
+// Write renders the entries and replaces the file when its content changed.
+// It reports whether the file was written.
+func (w *Writer) Write(entries map[string]string) (bool, error) {
+	content, err := w.render(entries)
+	if err != nil {
+		return false, err
+	}
+
+	// Leave the file alone when nothing changed so Traefik does not reload
+	current, err := os.ReadFile(w.path)
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("error reading %s: %w", w.path, err)
+	}
+
+	if err := writeAtomic(w.path, content); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// render encodes the entries in the configured format
+func (w *Writer) render(entries map[string]string) ([]byte, error) {
+	config := dynamic.Build(entries)
+
+	var buf bytes.Buffer
+	switch w.format {
+	case FormatTOML:
+		if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+			return nil, fmt.Errorf("error encoding TOML: %w", err)
+		}
+	default:
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(config); err != nil {
+			return nil, fmt.Errorf("error encoding YAML: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("error encoding YAML: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeAtomic writes content to a temporary file in the same directory and
+// renames it over path, so readers never see a partially written file
+func writeAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temporary file if anything below fails
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(content); err != nil {
+		return fmt.Errorf("error writing temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("error syncing temporary file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return fmt.Errorf("error setting file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+
+	success = true
+	return nil
+}
@@ -0,0 +1,118 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNewWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", path: "relay.yml", want: FormatYAML},
+		{name: "from extension", path: "relay.TOML", want: FormatTOML},
+		{name: "yml alias", path: "relay.conf", format: "YML", want: FormatYAML},
+		{name: "explicit format wins", path: "relay.toml", format: "yaml", want: FormatYAML},
+		{name: "unsupported format", path: "relay.json", format: "json", wantErr: true},
+		{name: "missing path", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewWriter(tt.path, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && w.format != tt.want {
+				t.Errorf("format = %q, want %q", w.format, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	entries := map[string]string{
+		"traefik/http/routers/whoami_a/entrypoints/0":        "websecure",
+		"traefik/http/routers/whoami_a/rule":                 "Host(`whoami.lan`)",
+		"traefik/http/routers/whoami_a/service":              "a",
+		"traefik/http/services/a/loadbalancer/servers/0/url": "http://10.0.0.2:80",
+	}
+
+	for _, format := range []string{FormatYAML, FormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "relay."+format)
+			w, err := NewWriter(path, "")
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+
+			if err := w.Publish(ctx, entries, entries, nil); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var config struct {
+				HTTP struct {
+					Routers map[string]struct {
+						EntryPoints []string `yaml:"entryPoints" toml:"entryPoints"`
+						Rule        string   `yaml:"rule" toml:"rule"`
+						Service     string   `yaml:"service" toml:"service"`
+					} `yaml:"routers" toml:"routers"`
+				} `yaml:"http" toml:"http"`
+			}
+			if format == FormatTOML {
+				_, err = toml.Decode(string(content), &config)
+			} else {
+				err = yaml.Unmarshal(content, &config)
+			}
+			if err != nil {
+				t.Fatalf("error decoding %s: %v", content, err)
+			}
+			router := config.HTTP.Routers["whoami_a"]
+			if router.Rule != "Host(`whoami.lan`)" || router.Service != "a" || len(router.EntryPoints) != 1 || router.EntryPoints[0] != "websecure" {
+				t.Errorf("router = %+v, want the published one", router)
+			}
+
+			// An unchanged configuration leaves the file alone
+			if written, err := w.Write(entries); err != nil || written {
+				t.Errorf("Write() of unchanged entries = %v, %v, want false", written, err)
+			}
+
+			// The file is emptied when the last route disappears
+			if err := w.Publish(ctx, map[string]string{}, nil, []string{"traefik/http/routers/whoami_a/rule"}); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+			content, err = os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(content), "whoami") {
+				t.Errorf("file after removing every route = %q", content)
+			}
+
+			// No temporary files are left behind
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Errorf("files = %v, want only the configuration file", files)
+			}
+		})
+	}
+}
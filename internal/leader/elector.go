@@ -0,0 +1,155 @@
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Lease is a lock with an expiry, held by at most one holder at a time
+type Lease interface {
+	AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, key, holder string) error
+	LeaseHolder(ctx context.Context, key string) (string, error)
+}
+
+// Status holds the leader election state of this replica
+type Status struct {
+	ID       string    `json:"id"`
+	Leader   string    `json:"leader"`
+	IsLeader bool      `json:"isLeader"`
+	Since    time.Time `json:"since"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Elector elects a single leader among the replicas sharing a lease key
+type Elector struct {
+	lease   Lease
+	key     string
+	id      string
+	ttl     time.Duration
+	elected chan struct{}
+	status  Status
+	mu      sync.RWMutex
+}
+
+// NewElector creates a new elector for the replica id. A leader that stops
+// renewing the lease is replaced after at most ttl.
+func NewElector(lease Lease, key, id string, ttl time.Duration) *Elector {
+	return &Elector{
+		lease:   lease,
+		key:     key,
+		id:      id,
+		ttl:     ttl,
+		elected: make(chan struct{}, 1),
+		status: Status{
+			ID:    id,
+			Since: time.Now(),
+		},
+	}
+}
+
+// Run campaigns for the lease until ctx is canceled, then releases it
+func (e *Elector) Run(ctx context.Context) {
+	// Renew well before the lease expires so a slow round trip does not cost the leadership
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			e.release()
+			return
+		}
+	}
+}
+
+// IsLeader reports whether this replica currently holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.status.IsLeader
+}
+
+// Elected is signaled each time this replica becomes the leader
+func (e *Elector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Status returns the current leader election state
+func (e *Elector) Status() Status {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.status
+}
+
+// campaign tries to take or renew the lease and records the outcome
+func (e *Elector) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.ttl/3)
+	defer cancel()
+
+	acquired, err := e.lease.AcquireLease(ctx, e.key, e.id, e.ttl)
+	if err != nil {
+		// Without a renewal the lease may already belong to someone else, so step down
+		log.Printf("Leader election error: %v", err)
+		e.update(false, "", err)
+		return
+	}
+
+	leader := e.id
+	if !acquired {
+		leader, err = e.lease.LeaseHolder(ctx, e.key)
+		if err != nil {
+			log.Printf("Leader election error: %v", err)
+		}
+	}
+
+	e.update(acquired, leader, err)
+}
+
+// update records the election state and logs leadership changes
+func (e *Elector) update(isLeader bool, leader string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if isLeader != e.status.IsLeader {
+		e.status.Since = time.Now()
+		if isLeader {
+			log.Printf("Replica '%s' became the leader", e.id)
+			select {
+			case e.elected <- struct{}{}:
+			default:
+			}
+		} else {
+			log.Printf("Replica '%s' is no longer the leader", e.id)
+		}
+	}
+
+	e.status.IsLeader = isLeader
+	e.status.Leader = leader
+	e.status.Error = ""
+	if err != nil {
+		e.status.Error = err.Error()
+	}
+}
+
+// release gives up the lease so another replica can take over immediately
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := e.lease.ReleaseLease(ctx, e.key, e.id); err != nil {
+		log.Printf("Error releasing leadership: %v", err)
+	}
+	e.update(false, "", nil)
+}
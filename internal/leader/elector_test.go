@@ -0,0 +1,128 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryLease is a Lease kept in memory, without expiry
+type memoryLease struct {
+	holders map[string]string
+	err     error
+	mu      sync.Mutex
+}
+
+func newMemoryLease() *memoryLease {
+	return &memoryLease{holders: make(map[string]string)}
+}
+
+func (l *memoryLease) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return false, l.err
+	}
+	if current, ok := l.holders[key]; ok && current != holder {
+		return false, nil
+	}
+	l.holders[key] = holder
+	return true, nil
+}
+
+func (l *memoryLease) ReleaseLease(ctx context.Context, key, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders[key] == holder {
+		delete(l.holders, key)
+	}
+	return nil
+}
+
+func (l *memoryLease) LeaseHolder(ctx context.Context, key string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.holders[key], l.err
+}
+
+// elected reports whether e was signaled as elected
+func elected(e *Elector) bool {
+	select {
+	case <-e.Elected():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	ctx := context.Background()
+	lease := newMemoryLease()
+	a := NewElector(lease, "relay:office:leader", "a", time.Minute)
+	b := NewElector(lease, "relay:office:leader", "b", time.Minute)
+
+	// The first replica takes the lease, the other one follows it
+	a.campaign(ctx)
+	b.campaign(ctx)
+	if !a.IsLeader() || !elected(a) {
+		t.Errorf("replica a is not the elected leader: %+v", a.Status())
+	}
+	if status := b.Status(); status.IsLeader || status.Leader != "a" || elected(b) {
+		t.Errorf("status of replica b = %+v, want following a", status)
+	}
+
+	// Renewing the lease does not signal a new election
+	a.campaign(ctx)
+	if !a.IsLeader() || elected(a) {
+		t.Errorf("renewal changed the leadership of replica a: %+v", a.Status())
+	}
+
+	// A replica that cannot renew its lease steps down
+	lease.err = errors.New("connection refused")
+	a.campaign(ctx)
+	if status := a.Status(); status.IsLeader || status.Error == "" {
+		t.Errorf("status of replica a = %+v, want stepped down with an error", status)
+	}
+	lease.err = nil
+
+	// A released lease is taken over by the next campaign
+	a.campaign(ctx)
+	a.release()
+	b.campaign(ctx)
+	if a.IsLeader() || !b.IsLeader() || !elected(b) {
+		t.Errorf("replica b did not take over: a = %+v, b = %+v", a.Status(), b.Status())
+	}
+}
+
+func TestRun(t *testing.T) {
+	lease := newMemoryLease()
+	e := NewElector(lease, "relay:office:leader", "a", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-e.Elected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica was not elected")
+	}
+
+	// Stopping hands over the lease
+	cancel()
+	<-done
+	if e.IsLeader() {
+		t.Error("replica still leads after stopping")
+	}
+	if holder, _ := lease.LeaseHolder(context.Background(), "relay:office:leader"); holder != "" {
+		t.Errorf("lease still held by %q after stopping", holder)
+	}
+}
@@ -0,0 +1,81 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultRouterTemplate is the name routers are published under unless
+// configured otherwise
+const DefaultRouterTemplate = "{{.Router}}_{{.Server}}"
+
+// Fields are the values available to name templates
+type Fields struct {
+	Router     string
+	Provider   string
+	Server     string
+	EntryPoint string
+}
+
+// sampleFields are used to check templates
+var sampleFields = Fields{Router: "router", Provider: "docker", Server: "server", EntryPoint: "web"}
+
+// Template generates router or service names
+type Template struct {
+	text string
+	tmpl *template.Template
+}
+
+// Parse parses a name template
+func Parse(text string) (*Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{text: text, tmpl: tmpl}
+
+	// Catch templates failing on every router, e.g. referencing unknown fields
+	if _, err := t.Execute(sampleFields); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Execute returns the name for the given fields
+func (t *Template) Execute(fields Fields) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("template '%s' generated an empty name", t.text)
+	}
+	if strings.ContainsAny(name, "/@ ") {
+		return "", fmt.Errorf("template '%s' generated '%s', names must not contain '/', '@' or spaces", t.text, name)
+	}
+	return name, nil
+}
+
+// DependsOn reports whether the generated name changes with the field set by
+// vary, e.g. whether routers of different servers get different names
+func (t *Template) DependsOn(vary func(*Fields, string)) bool {
+	a, b := sampleFields, sampleFields
+	vary(&a, "first")
+	vary(&b, "second")
+	nameA, errA := t.Execute(a)
+	nameB, errB := t.Execute(b)
+	return errA != nil || errB != nil || nameA != nameB
+}
+
+// SplitName splits a router name like "whoami@docker" into name and provider
+func SplitName(name string) (string, string) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
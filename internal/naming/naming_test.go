@@ -0,0 +1,118 @@
+package naming
+
+import "testing"
+
+func TestTemplateExecute(t *testing.T) {
+	fields := Fields{Router: "whoami", Provider: "docker", Server: "compute-1", EntryPoint: "web"}
+
+	tests := []struct {
+		name     string
+		template string
+		fields   func(*Fields)
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", template: DefaultRouterTemplate, want: "whoami_compute-1"},
+		{name: "all fields", template: "{{.Server}}-{{.Provider}}-{{.Router}}-{{.EntryPoint}}", want: "compute-1-docker-whoami-web"},
+		{name: "functions", template: `{{printf "%s.%s" .Router .Server}}`, want: "whoami.compute-1"},
+		{name: "surrounding spaces", template: " {{.Router}} ", want: "whoami"},
+		{
+			name:     "slash in field",
+			template: "{{.Router}}_{{.Provider}}",
+			fields:   func(f *Fields) { f.Provider = "kubernetes/crd" },
+			wantErr:  true,
+		},
+		{
+			name:     "empty for some routers",
+			template: `{{if ne .Provider "file"}}{{.Router}}{{end}}`,
+			fields:   func(f *Fields) { f.Provider = "file" },
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			f := fields
+			if tt.fields != nil {
+				tt.fields(&f)
+			}
+			got, err := tmpl.Execute(f)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"syntax", "{{.Router"},
+		{"unknown field", "{{.Host}}"},
+		{"slash", "{{.Server}}/{{.Router}}"},
+		{"at sign", "{{.Router}}@{{.Provider}}"},
+		{"inner space", "{{.Router}} {{.Server}}"},
+		{"empty", "{{if false}}x{{end}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.template); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", tt.template)
+			}
+		})
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	server := func(f *Fields, value string) { f.Server = value }
+
+	tests := []struct {
+		template string
+		want     bool
+	}{
+		{DefaultRouterTemplate, true},
+		{"{{.Router}}", false},
+		{"{{.Router}}_{{.EntryPoint}}", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := tmpl.DependsOn(server); got != tt.want {
+				t.Errorf("DependsOn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		input, name, provider string
+	}{
+		{"whoami@docker", "whoami", "docker"},
+		{"whoami", "whoami", ""},
+		{"api@internal@x", "api", "internal@x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			name, provider := SplitName(tt.input)
+			if name != tt.name || provider != tt.provider {
+				t.Errorf("SplitName() = %q, %q, want %q, %q", name, provider, tt.name, tt.provider)
+			}
+		})
+	}
+}
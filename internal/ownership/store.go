@@ -0,0 +1,284 @@
+// Package ownership publishes entries to key-value stores without a hash
+// type, such as Consul and etcd. Every relay instance records the keys it
+// published as a JSON list under relay/<instance>/owned, which Traefik
+// ignores, so that instances sharing a store never delete each other's keys.
+// The last known good entries of the servers of an instance are kept next to
+// its index, under relay/<instance>/servers/<server>.
+package ownership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// RelayPrefix is the prefix of the keys of all relay instances, such as their
+// ownership indexes
+const RelayPrefix = "relay/"
+
+// rootKey is the prefix of the keys read by Traefik
+const rootKey = "traefik/"
+
+// indexKeyFormat is the key holding the ownership index of a relay instance
+const indexKeyFormat = RelayPrefix + "%s/owned"
+
+// retainedPrefixFormat is the prefix of the keys holding the last known good
+// entries of the servers of a relay instance
+const retainedPrefixFormat = RelayPrefix + "%s/servers/"
+
+// maxAttempts is the number of times a publish is attempted when the
+// ownership index of another relay instance changes concurrently
+const maxAttempts = 3
+
+// ErrConflict is returned by Transport.Commit when a checked key was modified
+// since it was listed
+var ErrConflict = errors.New("transaction check failed")
+
+// KeyValue is a key with its value and the revision it was last modified at
+type KeyValue struct {
+	Key      string
+	Value    string
+	Revision uint64
+}
+
+// Op is an operation of a transaction, setting Key to Value or deleting it
+type Op struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// Transport is the part of a store client specific to the API of the store
+type Transport interface {
+	// List returns the keys below prefix, or none when there are no such keys
+	List(ctx context.Context, prefix string) ([]KeyValue, error)
+	// Commit applies ops unless one of checks was modified since it was
+	// listed, in which case it returns ErrConflict
+	Commit(ctx context.Context, checks []KeyValue, ops []Op) error
+}
+
+// Store publishes the entries of a relay instance through a transport and
+// keeps the ownership index of the instance in sync with them
+type Store struct {
+	transport Transport
+	instance  string
+	indexKey  string
+
+	retainedPrefix string
+}
+
+// index is the ownership index of a relay instance
+type index struct {
+	kv   KeyValue
+	keys []string
+}
+
+// NewStore creates a store publishing the entries of the given relay instance
+func NewStore(transport Transport, instance string) *Store {
+	return &Store{
+		transport: transport,
+		instance:  instance,
+		indexKey:  fmt.Sprintf(indexKeyFormat, instance),
+
+		retainedPrefix: fmt.Sprintf(retainedPrefixFormat, instance),
+	}
+}
+
+// Load returns the entries currently published by the relay instance, as
+// recorded in its ownership index
+func (s *Store) Load(ctx context.Context) (map[string]string, error) {
+	indexes, err := s.indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]string)
+	own, ok := indexes[s.instance]
+	if !ok {
+		return entries, nil
+	}
+
+	pairs, err := s.transport.List(ctx, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading entries: %w", err)
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Value
+	}
+
+	// Keys deleted outside of the relay are still owned, so they are recorded
+	// with an empty value and cleaned up if no longer relayed
+	for _, key := range own.keys {
+		entries[key] = values[key]
+	}
+
+	return entries, nil
+}
+
+// ForeignKeys returns the keys owned by other relay instances, mapped to the
+// owning instance
+func (s *Store) ForeignKeys(ctx context.Context) (map[string]string, error) {
+	indexes, err := s.indexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.foreignKeys(indexes), nil
+}
+
+// Publish removes the given keys and stores the updated entries in one
+// transaction, keeping the ownership index in sync with entries
+func (s *Store) Publish(ctx context.Context, entries, updated map[string]string, removed []string) error {
+	// The ownership indexes of other instances are checked in the transaction
+	// so that a concurrent change aborts it, which is then retried
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err = s.publish(ctx, entries, updated, removed)
+		if !errors.Is(err, ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+// publish applies a run unless another relay instance changed its ownership
+// index since it was read. Keys owned by other instances are neither
+// overwritten nor deleted.
+func (s *Store) publish(ctx context.Context, entries, updated map[string]string, removed []string) error {
+	indexes, err := s.indexes(ctx)
+	if err != nil {
+		return err
+	}
+	foreign := s.foreignKeys(indexes)
+	var checks []KeyValue
+	for owner, index := range indexes {
+		if owner != s.instance {
+			checks = append(checks, index.kv)
+		}
+	}
+
+	// New values first, so a split run never drops a router before its replacement
+	ops := make([]Op, 0, len(updated)+len(removed)+1)
+	for key, value := range updated {
+		if owner, ok := foreign[key]; ok {
+			log.Printf("Not publishing '%s', it is owned by relay instance '%s'", key, owner)
+			continue
+		}
+		ops = append(ops, Op{Key: key, Value: value})
+	}
+	for _, key := range removed {
+		if _, ok := foreign[key]; !ok {
+			ops = append(ops, Op{Key: key, Delete: true})
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		if _, ok := foreign[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	owned, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("error encoding owned keys: %w", err)
+	}
+	ops = append(ops, Op{Key: s.indexKey, Value: string(owned)})
+
+	return s.transport.Commit(ctx, checks, ops)
+}
+
+// LoadRetained returns the last known good entries of the servers of the
+// relay instance, by server name
+func (s *Store) LoadRetained(ctx context.Context) (map[string]map[string]string, error) {
+	pairs, err := s.transport.List(ctx, s.retainedPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading retained entries: %w", err)
+	}
+
+	retained := make(map[string]map[string]string, len(pairs))
+	for _, pair := range pairs {
+		server := strings.TrimPrefix(pair.Key, s.retainedPrefix)
+		var entries map[string]string
+		if err := json.Unmarshal([]byte(pair.Value), &entries); err != nil {
+			return nil, fmt.Errorf("error decoding retained entries of server '%s': %w", server, err)
+		}
+		retained[server] = entries
+	}
+
+	return retained, nil
+}
+
+// StoreRetained records the last known good entries of a server
+func (s *Store) StoreRetained(ctx context.Context, server string, entries map[string]string) error {
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return s.transport.Commit(ctx, nil, []Op{{Key: s.retainedPrefix + server, Value: string(value)}})
+}
+
+// DeleteRetained forgets the last known good entries of a server
+func (s *Store) DeleteRetained(ctx context.Context, server string) error {
+	return s.transport.Commit(ctx, nil, []Op{{Key: s.retainedPrefix + server, Delete: true}})
+}
+
+// indexes reads the ownership indexes of all relay instances, by instance
+func (s *Store) indexes(ctx context.Context) (map[string]index, error) {
+	pairs, err := s.transport.List(ctx, RelayPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ownership indexes: %w", err)
+	}
+
+	indexes := make(map[string]index)
+	for _, pair := range pairs {
+		owner, ok := indexOwner(pair.Key)
+		if !ok {
+			continue
+		}
+
+		var keys []string
+		if err := json.Unmarshal([]byte(pair.Value), &keys); err != nil {
+			return nil, fmt.Errorf("error decoding owned keys of '%s': %w", owner, err)
+		}
+		indexes[owner] = index{kv: pair, keys: keys}
+	}
+
+	return indexes, nil
+}
+
+// foreignKeys maps the keys owned by other relay instances to their owner
+func (s *Store) foreignKeys(indexes map[string]index) map[string]string {
+	foreign := make(map[string]string)
+	for owner, index := range indexes {
+		if owner == s.instance {
+			continue
+		}
+		for _, key := range index.keys {
+			foreign[key] = owner
+		}
+	}
+	return foreign
+}
+
+// indexOwner returns the relay instance of an ownership index key
+func indexOwner(key string) (string, bool) {
+	owner, ok := strings.CutPrefix(key, RelayPrefix)
+	if !ok {
+		return "", false
+	}
+	owner, ok = strings.CutSuffix(owner, "/owned")
+	if !ok || owner == "" || strings.Contains(owner, "/") {
+		return "", false
+	}
+	return owner, true
+}
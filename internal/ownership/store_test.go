@@ -0,0 +1,239 @@
+package ownership
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memoryTransport is a Transport keeping keys in a map
+type memoryTransport struct {
+	values   map[string]KeyValue
+	revision uint64
+	commits  int
+
+	// beforeCommit is called before each transaction is applied
+	beforeCommit func(m *memoryTransport)
+}
+
+func newMemoryTransport() *memoryTransport {
+	return &memoryTransport{values: make(map[string]KeyValue)}
+}
+
+// set stores a key as if written by another client
+func (m *memoryTransport) set(key, value string) {
+	m.revision++
+	m.values[key] = KeyValue{Key: key, Value: value, Revision: m.revision}
+}
+
+func (m *memoryTransport) List(ctx context.Context, prefix string) ([]KeyValue, error) {
+	var result []KeyValue
+	for key, kv := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, kv)
+		}
+	}
+	return result, nil
+}
+
+func (m *memoryTransport) Commit(ctx context.Context, checks []KeyValue, ops []Op) error {
+	m.commits++
+	if m.beforeCommit != nil {
+		m.beforeCommit(m)
+	}
+
+	for _, check := range checks {
+		if m.values[check.Key].Revision != check.Revision {
+			return ErrConflict
+		}
+	}
+	for _, op := range ops {
+		if op.Delete {
+			delete(m.values, op.Key)
+			continue
+		}
+		m.set(op.Key, op.Value)
+	}
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	transport := newMemoryTransport()
+	store := NewStore(transport, "office")
+
+	entries := map[string]string{
+		"traefik/http/routers/whoami_a/rule":    "Host(`a`)",
+		"traefik/http/routers/whoami_a/service": "a",
+	}
+	if err := store.Publish(ctx, entries, entries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := transport.values["relay/office/owned"].Value; got != `["traefik/http/routers/whoami_a/rule","traefik/http/routers/whoami_a/service"]` {
+		t.Errorf("ownership index = %s", got)
+	}
+
+	// Keys deleted outside of the relay are loaded with an empty value
+	delete(transport.values, "traefik/http/routers/whoami_a/service")
+	transport.set("traefik/http/routers/manual/rule", "Host(`manual`)")
+
+	loaded, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := map[string]string{
+		"traefik/http/routers/whoami_a/rule":    "Host(`a`)",
+		"traefik/http/routers/whoami_a/service": "",
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("Load() = %v, want %v", loaded, want)
+	}
+
+	// Another instance loads nothing
+	if loaded, err := NewStore(transport, "home").Load(ctx); err != nil || len(loaded) != 0 {
+		t.Errorf("Load() of another instance = %v, %v, want no entries", loaded, err)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	transport := newMemoryTransport()
+	office := NewStore(transport, "office")
+	home := NewStore(transport, "home")
+
+	homeEntries := map[string]string{"traefik/http/routers/shared/rule": "Host(`home`)"}
+	if err := home.Publish(ctx, homeEntries, homeEntries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	foreign, err := office.ForeignKeys(ctx)
+	if err != nil {
+		t.Fatalf("ForeignKeys() error = %v", err)
+	}
+	if want := map[string]string{"traefik/http/routers/shared/rule": "home"}; !reflect.DeepEqual(foreign, want) {
+		t.Errorf("ForeignKeys() = %v, want %v", foreign, want)
+	}
+
+	// Keys owned by another instance are neither overwritten, deleted nor claimed
+	officeEntries := map[string]string{
+		"traefik/http/routers/mine/rule":   "Host(`office`)",
+		"traefik/http/routers/shared/rule": "Host(`office`)",
+	}
+	if err := office.Publish(ctx, officeEntries, officeEntries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := office.Publish(ctx, officeEntries, nil, []string{"traefik/http/routers/shared/rule"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := transport.values["traefik/http/routers/shared/rule"].Value; got != "Host(`home`)" {
+		t.Errorf("key owned by another instance = %q, want it unchanged", got)
+	}
+	if got := transport.values["relay/office/owned"].Value; got != `["traefik/http/routers/mine/rule"]` {
+		t.Errorf("ownership index = %s, want only the own key", got)
+	}
+}
+
+func TestPublishConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		conflicts   int
+		wantErr     bool
+		wantCommits int
+	}{
+		{name: "no conflict", conflicts: 0, wantCommits: 1},
+		{name: "retried", conflicts: 1, wantCommits: 2},
+		{name: "too many conflicts", conflicts: maxAttempts, wantErr: true, wantCommits: maxAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			transport := newMemoryTransport()
+			office := NewStore(transport, "office")
+			home := NewStore(transport, "home")
+
+			homeEntries := map[string]string{"traefik/http/routers/home/rule": "Host(`home`)"}
+			if err := home.Publish(ctx, homeEntries, homeEntries, nil); err != nil {
+				t.Fatalf("Publish() error = %v", err)
+			}
+
+			// The other instance publishes while the transaction is in flight
+			transport.commits = 0
+			conflicts := tt.conflicts
+			transport.beforeCommit = func(m *memoryTransport) {
+				if conflicts > 0 {
+					conflicts--
+					m.set("relay/home/owned", m.values["relay/home/owned"].Value)
+				}
+			}
+
+			entries := map[string]string{"traefik/http/routers/office/rule": "Host(`office`)"}
+			err := office.Publish(ctx, entries, entries, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if transport.commits != tt.wantCommits {
+				t.Errorf("commits = %d, want %d", transport.commits, tt.wantCommits)
+			}
+			if _, ok := transport.values["traefik/http/routers/office/rule"]; ok == tt.wantErr {
+				t.Errorf("entry published = %v, want %v", ok, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIndexOwner(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{key: "relay/office/owned", want: "office", wantOk: true},
+		{key: "relay/office/servers/a"},
+		{key: "relay//owned"},
+		{key: "traefik/office/owned"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, ok := indexOwner(tt.key)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("indexOwner() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRetained(t *testing.T) {
+	ctx := context.Background()
+	transport := newMemoryTransport()
+	office := NewStore(transport, "office")
+
+	entries := map[string]string{"traefik/http/routers/whoami_a/rule": "Host(`a`)"}
+	if err := office.StoreRetained(ctx, "a", entries); err != nil {
+		t.Fatalf("StoreRetained() error = %v", err)
+	}
+	if err := office.StoreRetained(ctx, "b", entries); err != nil {
+		t.Fatalf("StoreRetained() error = %v", err)
+	}
+	if err := office.DeleteRetained(ctx, "b"); err != nil {
+		t.Fatalf("DeleteRetained() error = %v", err)
+	}
+
+	retained, err := office.LoadRetained(ctx)
+	if err != nil {
+		t.Fatalf("LoadRetained() error = %v", err)
+	}
+	if want := map[string]map[string]string{"a": entries}; !reflect.DeepEqual(retained, want) {
+		t.Errorf("LoadRetained() = %v, want %v", retained, want)
+	}
+
+	// Retained entries are neither published nor an ownership index
+	if loaded, err := office.Load(ctx); err != nil || len(loaded) != 0 {
+		t.Errorf("Load() = %v, %v, want no entries", loaded, err)
+	}
+	if retained, err := NewStore(transport, "home").LoadRetained(ctx); err != nil || len(retained) != 0 {
+		t.Errorf("LoadRetained() of another instance = %v, %v, want none", retained, err)
+	}
+}
@@ -2,19 +2,63 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// ownersKey is the hash mapping each published key to the relay instance that
+// owns it. It must not start with "traefik" or Traefik's Redis provider would
+// try to read it.
+const ownersKey = "relay:owners"
+
+// rootKey is the prefix of the keys read by Traefik
+const rootKey = "traefik/"
+
+// splitsKeyFormat is the hash holding the weights of traffic splits changed at
+// runtime, by split name, for a relay instance
+const splitsKeyFormat = "relay:%s:splits"
+
+// serversKeyFormat is the hash holding the last known good entries of the
+// servers of a relay instance, by server name
+const serversKeyFormat = "relay:%s:servers"
+
+// maxTxAttempts is the number of times a publish is attempted when the
+// ownership index changes concurrently
+const maxTxAttempts = 3
+
+// acquireScript renews the lease when it is held by the caller, or takes it when
+// it is free. It returns 1 when the caller holds the lease afterwards.
+var acquireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseScript deletes the lease only when it is held by the caller
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 // Client is a wrapper around redis.Client
 type Client struct {
-	rdb *redis.Client
+	rdb      *redis.Client
+	instance string
 }
 
-// NewClient creates a new Redis client
-func NewClient(addr string) (*Client, error) {
+// NewClient creates a new Redis client. Keys are owned by the given relay instance.
+func NewClient(addr, instance string) (*Client, error) {
 	opts, err := redis.ParseURL(fmt.Sprintf("redis://%s", addr))
 	if err != nil {
 		return nil, fmt.Errorf("invalid Redis URL: %w", err)
@@ -30,7 +74,7 @@ func NewClient(addr string) (*Client, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &Client{rdb: rdb}, nil
+	return &Client{rdb: rdb, instance: instance}, nil
 }
 
 // Close closes the Redis client
@@ -38,37 +82,238 @@ func (c *Client) Close() error {
 	return c.rdb.Close()
 }
 
-// FlushDB flushes the Redis database
-func (c *Client) FlushDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// Name returns the name of the backend
+func (c *Client) Name() string {
+	return "redis"
+}
+
+// Load returns the entries currently published by this relay instance, as
+// recorded in the ownership index
+func (c *Client) Load(ctx context.Context) (map[string]string, error) {
+	keys, err := c.ownedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys, err = c.adoptKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return entries, nil
+	}
+
+	values, err := c.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading owned entries: %w", err)
+	}
+
+	for i, value := range values {
+		// Keys deleted outside of the relay are still owned, so they are
+		// recorded with an empty value and cleaned up if no longer relayed
+		str, _ := value.(string)
+		entries[keys[i]] = str
+	}
+
+	return entries, nil
+}
+
+// ForeignKeys returns the keys owned by other relay instances, mapped to the
+// owning instance
+func (c *Client) ForeignKeys(ctx context.Context) (map[string]string, error) {
+	owners, err := c.rdb.HGetAll(ctx, ownersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading key owners: %w", err)
+	}
+
+	foreign := make(map[string]string)
+	for key, owner := range owners {
+		if owner != c.instance {
+			foreign[key] = owner
+		}
+	}
+
+	return foreign, nil
+}
+
+// Publish removes the given keys and stores the updated entries in Redis as a
+// single MULTI/EXEC transaction, so readers never observe a half-applied run.
+// Keys owned by other relay instances are neither overwritten nor deleted.
+func (c *Client) Publish(ctx context.Context, entries, updated map[string]string, removed []string) error {
+	publish := func(tx *redis.Tx) error {
+		deletable, err := c.ownedAmong(ctx, tx, removed)
+		if err != nil {
+			return err
+		}
+		storable, err := c.storableAmong(ctx, tx, updated)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			c.queueDelete(ctx, pipe, deletable)
+			c.queueStore(ctx, pipe, storable)
+			return nil
+		})
+		return err
+	}
+
+	// The ownership index is watched so that a concurrent change by another
+	// relay instance aborts the transaction, which is then retried
+	var err error
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err = c.rdb.Watch(ctx, publish, ownersKey)
+		if err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error publishing entries: %w", err)
+	}
+
+	return nil
+}
 
-	return c.rdb.FlushDB(ctx).Err()
+// Clear deletes every key owned by this relay instance, leaving other keys untouched
+func (c *Client) Clear(ctx context.Context) error {
+	keys, err := c.ownedKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.DeleteKeys(ctx, keys)
 }
 
-// StoreEntries stores multiple key-value pairs in Redis
+// StoreEntries stores multiple key-value pairs in Redis and marks them as
+// owned. Keys owned by other relay instances are left untouched.
 func (c *Client) StoreEntries(ctx context.Context, entries map[string]string) error {
 	if len(entries) == 0 {
 		return nil
 	}
 
+	storable, err := c.storableAmong(ctx, c.rdb, entries)
+	if err != nil {
+		return err
+	}
+
 	// Use a pipeline to efficiently execute multiple commands
 	pipe := c.rdb.Pipeline()
-	for key, value := range entries {
-		pipe.Set(ctx, key, value, 0)
-	}
+	c.queueStore(ctx, pipe, storable)
 
-	_, err := pipe.Exec(ctx)
+	_, err = pipe.Exec(ctx)
 	return err
 }
 
-// DeleteKeys deletes multiple keys from Redis
+// DeleteKeys deletes multiple keys from Redis and removes them from the
+// ownership index. Keys owned by other relay instances are left untouched.
 func (c *Client) DeleteKeys(ctx context.Context, keys []string) error {
-	if len(keys) == 0 {
+	owned, err := c.ownedAmong(ctx, c.rdb, keys)
+	if err != nil {
+		return err
+	}
+	if len(owned) == 0 {
 		return nil
 	}
 
-	return c.rdb.Del(ctx, keys...).Err()
+	pipe := c.rdb.Pipeline()
+	c.queueDelete(ctx, pipe, owned)
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// AcquireLease takes or renews the lease stored at key for holder. It reports
+// whether holder owns the lease afterwards.
+func (c *Client) AcquireLease(ctx context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	result, err := acquireScript.Run(ctx, c.rdb, []string{key}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+// ReleaseLease gives up the lease stored at key if it is held by holder
+func (c *Client) ReleaseLease(ctx context.Context, key, holder string) error {
+	return releaseScript.Run(ctx, c.rdb, []string{key}, holder).Err()
+}
+
+// LeaseHolder returns the current holder of the lease stored at key, or an
+// empty string when the lease is free
+func (c *Client) LeaseHolder(ctx context.Context, key string) (string, error) {
+	holder, err := c.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return holder, err
+}
+
+// LoadSplitWeights returns the weights of traffic splits changed at runtime, by split name
+func (c *Client) LoadSplitWeights(ctx context.Context) (map[string]map[string]int, error) {
+	values, err := c.rdb.HGetAll(ctx, fmt.Sprintf(splitsKeyFormat, c.instance)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading split weights: %w", err)
+	}
+
+	splits := make(map[string]map[string]int, len(values))
+	for name, value := range values {
+		var weights map[string]int
+		if err := json.Unmarshal([]byte(value), &weights); err != nil {
+			return nil, fmt.Errorf("error decoding weights of split '%s': %w", name, err)
+		}
+		splits[name] = weights
+	}
+
+	return splits, nil
+}
+
+// StoreSplitWeights records the weights of a traffic split changed at runtime
+func (c *Client) StoreSplitWeights(ctx context.Context, split string, weights map[string]int) error {
+	value, err := json.Marshal(weights)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, fmt.Sprintf(splitsKeyFormat, c.instance), split, value).Err()
+}
+
+// DeleteSplitWeights forgets the weights of a traffic split changed at runtime
+func (c *Client) DeleteSplitWeights(ctx context.Context, split string) error {
+	return c.rdb.HDel(ctx, fmt.Sprintf(splitsKeyFormat, c.instance), split).Err()
+}
+
+// LoadRetained returns the last known good entries of the servers, by server name
+func (c *Client) LoadRetained(ctx context.Context) (map[string]map[string]string, error) {
+	values, err := c.rdb.HGetAll(ctx, fmt.Sprintf(serversKeyFormat, c.instance)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading retained entries: %w", err)
+	}
+
+	retained := make(map[string]map[string]string, len(values))
+	for server, value := range values {
+		var entries map[string]string
+		if err := json.Unmarshal([]byte(value), &entries); err != nil {
+			return nil, fmt.Errorf("error decoding retained entries of server '%s': %w", server, err)
+		}
+		retained[server] = entries
+	}
+
+	return retained, nil
+}
+
+// StoreRetained records the last known good entries of a server
+func (c *Client) StoreRetained(ctx context.Context, server string, entries map[string]string) error {
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return c.rdb.HSet(ctx, fmt.Sprintf(serversKeyFormat, c.instance), server, value).Err()
+}
+
+// DeleteRetained forgets the last known good entries of a server
+func (c *Client) DeleteRetained(ctx context.Context, server string) error {
+	return c.rdb.HDel(ctx, fmt.Sprintf(serversKeyFormat, c.instance), server).Err()
 }
 
 // StringUpdateIfChanged updates a Redis key only if the value has changed
@@ -90,4 +335,140 @@ func (c *Client) StringUpdateIfChanged(ctx context.Context, key, value string) e
 // GetAllKeys gets all keys in the Redis database
 func (c *Client) GetAllKeys(ctx context.Context) ([]string, error) {
 	return c.rdb.Keys(ctx, "*").Result()
-}
\ No newline at end of file
+}
+
+// ownedAmong returns the keys that may be deleted by this relay instance.
+// Keys without an owner predate the ownership index and are included too.
+func (c *Client) ownedAmong(ctx context.Context, cmd redis.Cmdable, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	owners, err := cmd.HMGet(ctx, ownersKey, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading key owners: %w", err)
+	}
+
+	var owned []string
+	for i, owner := range owners {
+		if owner == nil || owner == c.instance {
+			owned = append(owned, keys[i])
+		}
+	}
+
+	return owned, nil
+}
+
+// storableAmong returns the entries that may be stored by this relay instance,
+// leaving out the keys owned by other instances
+func (c *Client) storableAmong(ctx context.Context, cmd redis.Cmdable, entries map[string]string) (map[string]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	owners, err := cmd.HMGet(ctx, ownersKey, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading key owners: %w", err)
+	}
+
+	storable := make(map[string]string, len(entries))
+	for i, owner := range owners {
+		if owner != nil && owner != c.instance {
+			log.Printf("Not publishing '%s', it is owned by relay instance '%s'", keys[i], owner)
+			continue
+		}
+		storable[keys[i]] = entries[keys[i]]
+	}
+
+	return storable, nil
+}
+
+// queueStore adds the commands storing entries and claiming them to pipe
+func (c *Client) queueStore(ctx context.Context, pipe redis.Pipeliner, entries map[string]string) {
+	if len(entries) == 0 {
+		return
+	}
+
+	owners := make(map[string]interface{}, len(entries))
+	for key, value := range entries {
+		pipe.Set(ctx, key, value, 0)
+		owners[key] = c.instance
+	}
+	pipe.HSet(ctx, ownersKey, owners)
+}
+
+// queueDelete adds the commands deleting keys and releasing them to pipe
+func (c *Client) queueDelete(ctx context.Context, pipe redis.Pipeliner, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	pipe.Del(ctx, keys...)
+	pipe.HDel(ctx, ownersKey, keys...)
+}
+
+// ownedKeys returns the keys owned by this relay instance
+func (c *Client) ownedKeys(ctx context.Context) ([]string, error) {
+	owners, err := c.rdb.HGetAll(ctx, ownersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading key owners: %w", err)
+	}
+
+	var keys []string
+	for key, owner := range owners {
+		if owner == c.instance {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// adoptKeys claims the keys below rootKey while the ownership index is empty.
+// They were published by a relay predating the index, so claiming them lets
+// the next run delete those no longer relayed. It returns the claimed keys.
+func (c *Client) adoptKeys(ctx context.Context) ([]string, error) {
+	var keys []string
+	adopt := func(tx *redis.Tx) error {
+		count, err := tx.HLen(ctx, ownersKey).Result()
+		if err != nil || count > 0 {
+			return err
+		}
+
+		iter := tx.Scan(ctx, 0, rootKey+"*", 1000).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil || len(keys) == 0 {
+			return err
+		}
+
+		owners := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			owners[key] = c.instance
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, ownersKey, owners)
+			return nil
+		})
+		return err
+	}
+
+	// Another relay instance adopting the keys at the same time wins
+	err := c.rdb.Watch(ctx, adopt, ownersKey)
+	if err == redis.TxFailedErr {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error adopting unowned keys: %w", err)
+	}
+	if len(keys) > 0 {
+		log.Printf("Adopted %d keys published before keys had owners", len(keys))
+	}
+
+	return keys, nil
+}
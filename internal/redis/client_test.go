@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestClient returns a client of the given relay instance connected to s
+func newTestClient(t *testing.T, s *miniredis.Miniredis, instance string) *Client {
+	t.Helper()
+
+	c, err := NewClient(s.Addr(), instance)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	office := newTestClient(t, s, "office")
+
+	entries := map[string]string{
+		"traefik/http/routers/whoami_a/rule":    "Host(`a`)",
+		"traefik/http/routers/whoami_a/service": "a",
+	}
+	if err := office.Publish(ctx, entries, entries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := s.HGet(ownersKey, "traefik/http/routers/whoami_a/rule"); got != "office" {
+		t.Errorf("owner = %q, want office", got)
+	}
+
+	// Keys deleted outside of the relay are loaded with an empty value
+	s.Del("traefik/http/routers/whoami_a/service")
+	s.Set("traefik/http/routers/manual/rule", "Host(`manual`)")
+
+	loaded, err := office.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := map[string]string{
+		"traefik/http/routers/whoami_a/rule":    "Host(`a`)",
+		"traefik/http/routers/whoami_a/service": "",
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("Load() = %v, want %v", loaded, want)
+	}
+
+	// Removed keys are deleted and released
+	if err := office.Publish(ctx, map[string]string{}, nil, []string{"traefik/http/routers/whoami_a/rule"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if s.Exists("traefik/http/routers/whoami_a/rule") {
+		t.Error("removed key still exists")
+	}
+	if got := s.HGet(ownersKey, "traefik/http/routers/whoami_a/rule"); got != "" {
+		t.Errorf("removed key still owned by %q", got)
+	}
+
+	// Clear leaves keys it does not own alone
+	if err := office.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if !s.Exists("traefik/http/routers/manual/rule") {
+		t.Error("Clear() deleted a key the relay does not own")
+	}
+}
+
+func TestAdoptKeys(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	office := newTestClient(t, s, "office")
+
+	// Keys published before the ownership index existed
+	s.Set("traefik/http/routers/whoami_a/rule", "Host(`a`)")
+	s.Set("traefik/http/routers/stale/rule", "Host(`stale`)")
+	s.Set("other", "value")
+
+	loaded, err := office.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := map[string]string{
+		"traefik/http/routers/whoami_a/rule": "Host(`a`)",
+		"traefik/http/routers/stale/rule":    "Host(`stale`)",
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("Load() = %v, want %v", loaded, want)
+	}
+
+	// Adopted keys no longer relayed are deleted by the next run
+	entries := map[string]string{"traefik/http/routers/whoami_a/rule": "Host(`a`)"}
+	if err := office.Publish(ctx, entries, nil, []string{"traefik/http/routers/stale/rule"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if s.Exists("traefik/http/routers/stale/rule") {
+		t.Error("stale adopted key still exists")
+	}
+	if got := s.HGet(ownersKey, "traefik/http/routers/whoami_a/rule"); got != "office" {
+		t.Errorf("owner of adopted key = %q, want office", got)
+	}
+
+	// Once the index is populated, other instances adopt nothing
+	s.Set("traefik/http/routers/manual/rule", "Host(`manual`)")
+	if loaded, err := newTestClient(t, s, "home").Load(ctx); err != nil || len(loaded) != 0 {
+		t.Errorf("Load() of another instance = %v, %v, want no entries", loaded, err)
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	office := newTestClient(t, s, "office")
+	home := newTestClient(t, s, "home")
+
+	homeEntries := map[string]string{"traefik/http/routers/shared/rule": "Host(`home`)"}
+	if err := home.Publish(ctx, homeEntries, homeEntries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	foreign, err := office.ForeignKeys(ctx)
+	if err != nil {
+		t.Fatalf("ForeignKeys() error = %v", err)
+	}
+	if want := map[string]string{"traefik/http/routers/shared/rule": "home"}; !reflect.DeepEqual(foreign, want) {
+		t.Errorf("ForeignKeys() = %v, want %v", foreign, want)
+	}
+
+	// Keys owned by another instance are neither overwritten, deleted nor claimed
+	officeEntries := map[string]string{
+		"traefik/http/routers/mine/rule":   "Host(`office`)",
+		"traefik/http/routers/shared/rule": "Host(`office`)",
+	}
+	if err := office.Publish(ctx, officeEntries, officeEntries, nil); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := office.Publish(ctx, officeEntries, nil, []string{"traefik/http/routers/shared/rule"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got, _ := s.Get("traefik/http/routers/shared/rule"); got != "Host(`home`)" {
+		t.Errorf("key owned by another instance = %q, want it unchanged", got)
+	}
+	if got := s.HGet(ownersKey, "traefik/http/routers/shared/rule"); got != "home" {
+		t.Errorf("owner = %q, want home", got)
+	}
+	if got, _ := s.Get("traefik/http/routers/mine/rule"); got != "Host(`office`)" {
+		t.Errorf("own key = %q, want it published", got)
+	}
+}
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	c := newTestClient(t, s, "office")
+
+	tests := []struct {
+		name   string
+		holder string
+		want   bool
+	}{
+		{name: "free lease is taken", holder: "a", want: true},
+		{name: "held lease is renewed", holder: "a", want: true},
+		{name: "held lease is refused", holder: "b", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.AcquireLease(ctx, "relay:office:leader", tt.holder, time.Minute)
+			if err != nil || got != tt.want {
+				t.Errorf("AcquireLease() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if holder, err := c.LeaseHolder(ctx, "relay:office:leader"); err != nil || holder != "a" {
+		t.Errorf("LeaseHolder() = %q, %v, want a", holder, err)
+	}
+
+	// Only the holder releases the lease
+	if err := c.ReleaseLease(ctx, "relay:office:leader", "b"); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	if !s.Exists("relay:office:leader") {
+		t.Error("lease released by another replica")
+	}
+	if err := c.ReleaseLease(ctx, "relay:office:leader", "a"); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	if holder, err := c.LeaseHolder(ctx, "relay:office:leader"); err != nil || holder != "" {
+		t.Errorf("LeaseHolder() = %q, %v, want a free lease", holder, err)
+	}
+
+	// An expired lease is free again
+	if _, err := c.AcquireLease(ctx, "relay:office:leader", "a", time.Second); err != nil {
+		t.Fatalf("AcquireLease() error = %v", err)
+	}
+	s.FastForward(2 * time.Second)
+	if got, err := c.AcquireLease(ctx, "relay:office:leader", "b", time.Minute); err != nil || !got {
+		t.Errorf("AcquireLease() of an expired lease = %v, %v, want true", got, err)
+	}
+}
+
+func TestSplitWeights(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	office := newTestClient(t, s, "office")
+
+	if err := office.StoreSplitWeights(ctx, "canary", map[string]int{"a": 90, "b": 10}); err != nil {
+		t.Fatalf("StoreSplitWeights() error = %v", err)
+	}
+	if err := office.StoreSplitWeights(ctx, "blue-green", map[string]int{"a": 0, "b": 100}); err != nil {
+		t.Fatalf("StoreSplitWeights() error = %v", err)
+	}
+	if err := office.DeleteSplitWeights(ctx, "blue-green"); err != nil {
+		t.Fatalf("DeleteSplitWeights() error = %v", err)
+	}
+
+	splits, err := office.LoadSplitWeights(ctx)
+	if err != nil {
+		t.Fatalf("LoadSplitWeights() error = %v", err)
+	}
+	if want := map[string]map[string]int{"canary": {"a": 90, "b": 10}}; !reflect.DeepEqual(splits, want) {
+		t.Errorf("LoadSplitWeights() = %v, want %v", splits, want)
+	}
+
+	// Weights are kept per relay instance
+	if splits, err := newTestClient(t, s, "home").LoadSplitWeights(ctx); err != nil || len(splits) != 0 {
+		t.Errorf("LoadSplitWeights() of another instance = %v, %v, want none", splits, err)
+	}
+}
+
+func TestRetained(t *testing.T) {
+	ctx := context.Background()
+	s := miniredis.RunT(t)
+	office := newTestClient(t, s, "office")
+
+	entries := map[string]string{"traefik/http/routers/whoami_a/rule": "Host(`a`)"}
+	if err := office.StoreRetained(ctx, "a", entries); err != nil {
+		t.Fatalf("StoreRetained() error = %v", err)
+	}
+	if err := office.StoreRetained(ctx, "b", entries); err != nil {
+		t.Fatalf("StoreRetained() error = %v", err)
+	}
+	if err := office.DeleteRetained(ctx, "b"); err != nil {
+		t.Fatalf("DeleteRetained() error = %v", err)
+	}
+
+	retained, err := office.LoadRetained(ctx)
+	if err != nil {
+		t.Fatalf("LoadRetained() error = %v", err)
+	}
+	if want := map[string]map[string]string{"a": entries}; !reflect.DeepEqual(retained, want) {
+		t.Errorf("LoadRetained() = %v, want %v", retained, want)
+	}
+
+	// Retained entries are not published entries
+	keys := s.Keys()
+	sort.Strings(keys)
+	if want := []string{"relay:office:servers"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,188 @@
+package rule
+
+import "fmt"
+
+// Expr is a node of a parsed rule
+type Expr interface {
+	expr()
+}
+
+// Matcher is a matcher call like Host(`example.com`)
+type Matcher struct {
+	Name string
+	Args []string
+}
+
+// Not negates an expression
+type Not struct {
+	Expr Expr
+}
+
+// And matches when both expressions match
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches when either expression matches
+type Or struct {
+	Left, Right Expr
+}
+
+func (*Matcher) expr() {}
+func (*Not) expr()     {}
+func (*And) expr()     {}
+func (*Or) expr()      {}
+
+// Transform returns expr with every matcher replaced by the result of fn
+func Transform(expr Expr, fn func(*Matcher) Expr) Expr {
+	switch e := expr.(type) {
+	case *Matcher:
+		return fn(e)
+	case *Not:
+		return &Not{Expr: Transform(e.Expr, fn)}
+	case *And:
+		return &And{Left: Transform(e.Left, fn), Right: Transform(e.Right, fn)}
+	case *Or:
+		return &Or{Left: Transform(e.Left, fn), Right: Transform(e.Right, fn)}
+	}
+	return expr
+}
+
+// Parse parses a Traefik router rule. The grammar follows Traefik: matchers
+// combined with !, && and ||, in decreasing order of precedence, and
+// parentheses.
+func Parse(rule string) (Expr, error) {
+	tokens, err := lex(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return expr, nil
+}
+
+// parser is a recursive descent parser over the tokens of a rule
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the current token
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next consumes the current token
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// expect consumes a token of the given kind
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, fmt.Errorf("expected %s at position %d, got %s", what, t.pos, t)
+	}
+	return t, nil
+}
+
+// parseOr parses expressions joined by ||
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses expressions joined by &&
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses negations, groups and matchers
+func (p *parser) parseUnary() (Expr, error) {
+	switch t := p.peek(); t.kind {
+	case tokenNot:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	case tokenLParen:
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokenIdent:
+		return p.parseMatcher()
+	default:
+		return nil, fmt.Errorf("expected matcher at position %d, got %s", t.pos, t)
+	}
+}
+
+// parseMatcher parses a matcher call with its string arguments
+func (p *parser) parseMatcher() (Expr, error) {
+	name := p.next()
+	if _, err := p.expect(tokenLParen, "'(' after "+name.value); err != nil {
+		return nil, err
+	}
+
+	m := &Matcher{Name: name.value}
+	if p.peek().kind == tokenRParen {
+		p.next()
+		return m, nil
+	}
+	for {
+		arg, err := p.expect(tokenString, "string")
+		if err != nil {
+			return nil, err
+		}
+		m.Args = append(m.Args, arg.value)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			return m, nil
+		}
+		if t.kind != tokenComma {
+			return nil, fmt.Errorf("expected ',' or ')' at position %d, got %s", t.pos, t)
+		}
+	}
+}
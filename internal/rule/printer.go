@@ -0,0 +1,73 @@
+package rule
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Operator precedences, parentheses are only printed where needed
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+)
+
+// String prints an expression as a Traefik rule
+func String(expr Expr) string {
+	var b strings.Builder
+	write(&b, expr, precOr)
+	return b.String()
+}
+
+// write prints expr, in parentheses when it binds looser than its context
+func write(b *strings.Builder, expr Expr, context int) {
+	switch e := expr.(type) {
+	case *Matcher:
+		b.WriteString(e.Name)
+		b.WriteByte('(')
+		for i, arg := range e.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(quote(arg))
+		}
+		b.WriteByte(')')
+	case *Not:
+		b.WriteByte('!')
+		write(b, e.Expr, precNot)
+	case *And:
+		open(b, precAnd, context)
+		write(b, e.Left, precAnd)
+		b.WriteString(" && ")
+		write(b, e.Right, precAnd)
+		closeGroup(b, precAnd, context)
+	case *Or:
+		open(b, precOr, context)
+		write(b, e.Left, precOr)
+		b.WriteString(" || ")
+		write(b, e.Right, precOr)
+		closeGroup(b, precOr, context)
+	}
+}
+
+// open starts a group when an operator binds looser than its context
+func open(b *strings.Builder, prec, context int) {
+	if prec < context {
+		b.WriteByte('(')
+	}
+}
+
+// closeGroup ends a group started by open
+func closeGroup(b *strings.Builder, prec, context int) {
+	if prec < context {
+		b.WriteByte(')')
+	}
+}
+
+// quote prints a matcher argument, as a raw string unless it contains a backtick
+func quote(value string) string {
+	if strings.Contains(value, "`") {
+		return strconv.Quote(value)
+	}
+	return "`" + value + "`"
+}
@@ -0,0 +1,137 @@
+package rule
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []token
+	}{
+		{
+			input: "Host(`a.com`)",
+			want: []token{
+				{tokenIdent, "Host", 0},
+				{tokenLParen, "(", 4},
+				{tokenString, "a.com", 5},
+				{tokenRParen, ")", 12},
+				{tokenEOF, "", 13},
+			},
+		},
+		{
+			input: `!Path("/a\"b") || X()`,
+			want: []token{
+				{tokenNot, "!", 0},
+				{tokenIdent, "Path", 1},
+				{tokenLParen, "(", 5},
+				{tokenString, `/a"b`, 6},
+				{tokenRParen, ")", 13},
+				{tokenOr, "||", 15},
+				{tokenIdent, "X", 18},
+				{tokenLParen, "(", 19},
+				{tokenRParen, ")", 20},
+				{tokenEOF, "", 21},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := lex(tt.input)
+			if err != nil {
+				t.Fatalf("lex() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"matcher", "Host(`a.com`)", "Host(`a.com`)"},
+		{"several arguments", "Host(`a.com`,`b.com`)", "Host(`a.com`, `b.com`)"},
+		{"no arguments", "ClientIP()", "ClientIP()"},
+		{"double quotes", `Host("a.com")`, "Host(`a.com`)"},
+		{"backtick in argument", "Path(\"/a`b\")", "Path(\"/a`b\")"},
+		{"and", "Host(`a.com`)&&PathPrefix(`/api`)", "Host(`a.com`) && PathPrefix(`/api`)"},
+		{"or binds looser than and", "Host(`a`) || Host(`b`) && Path(`/`)", "Host(`a`) || Host(`b`) && Path(`/`)"},
+		{"needed parentheses", "(Host(`a`) || Host(`b`)) && Path(`/`)", "(Host(`a`) || Host(`b`)) && Path(`/`)"},
+		{"redundant parentheses", "((Host(`a`))) && (Path(`/`))", "Host(`a`) && Path(`/`)"},
+		{"not", "!Path(`/admin`)", "!Path(`/admin`)"},
+		{"not of group", "!(Host(`a`) || Host(`b`))", "!(Host(`a`) || Host(`b`))"},
+		{"whitespace", " Host( `a` ,\n`b` ) ", "Host(`a`, `b`)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := String(expr)
+			if got != tt.want {
+				t.Fatalf("String() = %q, want %q", got, tt.want)
+			}
+
+			// Printed rules parse back to the same expression
+			again, err := Parse(got)
+			if err != nil {
+				t.Fatalf("Parse() of printed rule error = %v", err)
+			}
+			if !reflect.DeepEqual(again, expr) {
+				t.Errorf("printed rule %q does not parse back to the same expression", got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"unterminated string", "Host(`a.com)"},
+		{"unterminated quoted string", `Host("a.com)`},
+		{"missing parenthesis", "Host(`a.com`"},
+		{"missing argument list", "Host"},
+		{"unquoted argument", "Host(a.com)"},
+		{"dangling operator", "Host(`a`) &&"},
+		{"trailing tokens", "Host(`a`) Path(`/`)"},
+		{"unexpected character", "Host(`a`) & Path(`/`)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.input); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", tt.input)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	expr, err := Parse("Host(`a.local`) && !Path(`/admin`)")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	got := String(Transform(expr, func(m *Matcher) Expr {
+		if m.Name != "Host" {
+			return m
+		}
+		return &Matcher{Name: m.Name, Args: []string{"a.example.com"}}
+	}))
+	want := "Host(`a.example.com`) && !Path(`/admin`)"
+	if got != want {
+		t.Errorf("Transform() = %q, want %q", got, want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -60,6 +61,41 @@ func (c *Client) GetTcpRouters(ctx context.Context) ([]TcpRouter, error) {
 	return routers, nil
 }
 
+// GetUdpRouters fetches all UDP routers from the Traefik API
+func (c *Client) GetUdpRouters(ctx context.Context) ([]UdpRouter, error) {
+	req, err := c.createRequest(ctx, "api/udp/routers")
+	if err != nil {
+		return nil, err
+	}
+
+	var routers []UdpRouter
+	if err := c.doRequest(req, &routers); err != nil {
+		// Traefik versions without UDP support, or without UDP entrypoints,
+		// do not serve the endpoint, so there is nothing to relay
+		if isNotEnabled(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return routers, nil
+}
+
+// GetEntryPoints fetches all entrypoints from the Traefik API
+func (c *Client) GetEntryPoints(ctx context.Context) ([]EntryPoint, error) {
+	req, err := c.createRequest(ctx, "api/entrypoints")
+	if err != nil {
+		return nil, err
+	}
+
+	var entryPoints []EntryPoint
+	if err := c.doRequest(req, &entryPoints); err != nil {
+		return nil, err
+	}
+
+	return entryPoints, nil
+}
+
 // GetMiddlewares fetches all middlewares from the Traefik API
 func (c *Client) GetMiddlewares(ctx context.Context) ([]Middleware, error) {
 	req, err := c.createRequest(ctx, "api/http/middlewares")
@@ -135,7 +171,7 @@ func (c *Client) doRequest(req *http.Request, result interface{}) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return &apiError{status: resp.StatusCode, body: string(body)}
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
@@ -143,4 +179,24 @@ func (c *Client) doRequest(req *http.Request, result interface{}) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// apiError is an error response of the Traefik API
+type apiError struct {
+	status int
+	body   string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.status, e.body)
+}
+
+// isNotEnabled reports whether err is a response of the Traefik API telling
+// that the requested endpoint does not exist or is not enabled
+func isNotEnabled(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.status == http.StatusNotFound || strings.Contains(strings.ToLower(apiErr.body), "not enabled")
+}
@@ -10,6 +10,22 @@ type HttpRouter struct {
 	Priority    int64    `json:"priority"`
 	Status      string   `json:"status"`
 	Provider    string   `json:"provider"`
+	Error       []string `json:"error,omitempty"`
+	TLS         *TLS     `json:"tls,omitempty"`
+}
+
+// TLS represents the TLS configuration of a router, Passthrough only applies to TCP routers
+type TLS struct {
+	CertResolver string   `json:"certResolver,omitempty"`
+	Domains      []Domain `json:"domains,omitempty"`
+	Options      string   `json:"options,omitempty"`
+	Passthrough  bool     `json:"passthrough,omitempty"`
+}
+
+// Domain represents a domain a certificate is requested for
+type Domain struct {
+	Main string   `json:"main"`
+	SANs []string `json:"sans,omitempty"`
 }
 
 // TcpRouter represents a Traefik TCP router configuration
@@ -21,6 +37,24 @@ type TcpRouter struct {
 	Priority    int64    `json:"priority"`
 	Status      string   `json:"status"`
 	Provider    string   `json:"provider"`
+	Error       []string `json:"error,omitempty"`
+	TLS         *TLS     `json:"tls,omitempty"`
+}
+
+// UdpRouter represents a Traefik UDP router configuration
+type UdpRouter struct {
+	EntryPoints []string `json:"entryPoints"`
+	Service     string   `json:"service"`
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	Provider    string   `json:"provider"`
+	Error       []string `json:"error,omitempty"`
+}
+
+// EntryPoint represents a Traefik entrypoint
+type EntryPoint struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
 }
 
 // Middleware represents a Traefik middleware configuration
@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ConflictChecker is implemented by publishers whose store can be shared by
+// several relay instances
+type ConflictChecker interface {
+	// ForeignKeys returns the keys owned by other relay instances, mapped to the owning instance
+	ForeignKeys(ctx context.Context) (map[string]string, error)
+}
+
+// Conflict describes a router or service that is not published because
+// another relay instance already publishes it
+type Conflict struct {
+	Protocol string `json:"protocol"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+}
+
+// resolveConflicts removes the routers and services owned by other relay
+// instances from entries, together with the routers using such a service
+func (w *Worker) resolveConflicts(ctx context.Context, entries map[string]string) ([]Conflict, error) {
+	foreign := make(map[string]string)
+	for _, publisher := range w.publishers {
+		checker, ok := publisher.(ConflictChecker)
+		if !ok {
+			continue
+		}
+
+		keys, err := checker.ForeignKeys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading foreign keys from %s: %w", publisher.Name(), err)
+		}
+		for key, owner := range keys {
+			foreign[key] = owner
+		}
+	}
+
+	// Find the objects (e.g. traefik/http/routers/<name>) owned by another instance
+	conflicting := make(map[string]Conflict)
+	for key := range entries {
+		owner, ok := foreign[key]
+		if !ok {
+			continue
+		}
+
+		segments := strings.SplitN(key, "/", 5)
+		if len(segments) < 4 {
+			continue
+		}
+		prefix := strings.Join(segments[:4], "/")
+		conflicting[prefix] = Conflict{
+			Protocol: segments[1],
+			Kind:     segments[2],
+			Name:     segments[3],
+			Owner:    owner,
+		}
+	}
+
+	if len(conflicting) == 0 {
+		return nil, nil
+	}
+
+	// Routers using a conflicting service would be routed to the other instance's backend
+	for prefix, conflict := range conflicting {
+		if conflict.Kind != "services" {
+			continue
+		}
+		for key, value := range entries {
+			segments := strings.Split(key, "/")
+			if len(segments) == 5 && segments[1] == conflict.Protocol && segments[2] == "routers" &&
+				segments[4] == "service" && value == conflict.Name {
+				routerPrefix := strings.Join(segments[:4], "/")
+				if _, exists := conflicting[routerPrefix]; !exists {
+					conflicting[routerPrefix] = Conflict{
+						Protocol: conflict.Protocol,
+						Kind:     "routers",
+						Name:     segments[3],
+						Owner:    conflicting[prefix].Owner,
+					}
+				}
+			}
+		}
+	}
+
+	// Drop every key of the conflicting objects
+	for key := range entries {
+		segments := strings.SplitN(key, "/", 5)
+		if len(segments) < 4 {
+			continue
+		}
+		if _, exists := conflicting[strings.Join(segments[:4], "/")]; exists {
+			delete(entries, key)
+		}
+	}
+
+	conflicts := make([]Conflict, 0, len(conflicting))
+	for _, conflict := range conflicting {
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	for _, conflict := range conflicts {
+		log.Printf("Skipping %s %s '%s': already published by relay instance '%s'",
+			conflict.Protocol, strings.TrimSuffix(conflict.Kind, "s"), conflict.Name, conflict.Owner)
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// FailoverRoute describes routers of several servers that were published as
+// one router of a failover group
+type FailoverRoute struct {
+	Group   string   `json:"group"`
+	Router  string   `json:"router"`
+	Service string   `json:"service"`
+	Servers []string `json:"servers"`
+}
+
+// failoverMember is the router a server of a failover group publishes for a route
+type failoverMember struct {
+	server string
+	router string
+}
+
+// mergeFailoverGroups replaces the HTTP routers with the same rule and
+// entrypoints on several servers of a group by the router of the highest
+// ranked server, pointing at a chain of failover services. serverEntries
+// holds the entries of every server and is updated in place.
+func mergeFailoverGroups(groups []config.FailoverGroup, serverEntries map[string]map[string]string) []FailoverRoute {
+	var merged []FailoverRoute
+	for _, group := range groups {
+		var keys []string
+		routes := make(map[string][]failoverMember)
+		ambiguous := make(map[string]bool)
+		for _, server := range group.Servers {
+			entries := serverEntries[server]
+			if entries == nil {
+				continue
+			}
+
+			// The entries of a server may be its retained state, never change those
+			entries = copyEntries(entries)
+			serverEntries[server] = entries
+
+			seen := make(map[string]bool)
+			for _, router := range httpRouterNames(entries) {
+				key := routeKey(entries, router)
+				if seen[key] {
+					// Several routers of one server for the same route cannot be told apart
+					log.Printf("Not merging route of router '%s' on server '%s' in failover group '%s': used by several routers",
+						router, server, group.Name)
+					ambiguous[key] = true
+					continue
+				}
+				seen[key] = true
+				if _, ok := routes[key]; !ok {
+					keys = append(keys, key)
+				}
+				routes[key] = append(routes[key], failoverMember{server: server, router: router})
+			}
+		}
+
+		for _, key := range keys {
+			members := routes[key]
+			if len(members) < 2 || ambiguous[key] {
+				continue
+			}
+			merged = append(merged, mergeRoute(group.Name, members, serverEntries))
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Group != merged[j].Group {
+			return merged[i].Group < merged[j].Group
+		}
+		return merged[i].Router < merged[j].Router
+	})
+	return merged
+}
+
+// mergeRoute publishes the route of the first member with a failover service
+// over the services of all members and removes the routers of the others.
+// Three or more members are chained: each failover service falls back to the
+// next one.
+func mergeRoute(group string, members []failoverMember, serverEntries map[string]map[string]string) FailoverRoute {
+	primary := members[0]
+	primaryEntries := serverEntries[primary.server]
+
+	services := make([]string, len(members))
+	servers := make([]string, len(members))
+	for i, member := range members {
+		services[i] = serverEntries[member.server][getRedisKey("http", "routers", member.router, "service")]
+		servers[i] = member.server
+		if i > 0 {
+			deleteRouter(serverEntries[member.server], "http", member.router)
+		}
+	}
+
+	name := primary.router + "_failover"
+	for i := 0; i < len(services)-1; i++ {
+		service := failoverServiceName(name, i)
+		fallback := services[i+1]
+		if i < len(services)-2 {
+			fallback = failoverServiceName(name, i+1)
+		}
+		primaryEntries[getRedisKey("http", "services", service, "failover", "service")] = services[i]
+		primaryEntries[getRedisKey("http", "services", service, "failover", "fallback")] = fallback
+		if i > 0 {
+			// The parent failover service only learns about the health of
+			// a fallback that checks its own children
+			primaryEntries[getRedisKey("http", "services", service, "failover", "healthcheck")] = "true"
+		}
+	}
+	primaryEntries[getRedisKey("http", "routers", primary.router, "service")] = name
+
+	log.Printf("Merged router '%s' of servers %s into failover group '%s'", primary.router, strings.Join(servers, ", "), group)
+	return FailoverRoute{Group: group, Router: primary.router, Service: name, Servers: servers}
+}
+
+// failoverServiceName returns the name of the i-th failover service of a chain
+func failoverServiceName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + "_" + itoa(i+1)
+}
+
+// httpRouterNames returns the sorted names of the HTTP routers in entries
+func httpRouterNames(entries map[string]string) []string {
+	var names []string
+	prefix := getRedisKey("http", "routers") + "/"
+	for key := range entries {
+		if name, ok := strings.CutPrefix(key, prefix); ok && strings.HasSuffix(name, "/rule") {
+			names = append(names, strings.TrimSuffix(name, "/rule"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routeKey identifies the route of a router by its rule and entrypoints
+func routeKey(entries map[string]string, router string) string {
+	var entryPoints []string
+	prefix := getRedisKey("http", "routers", router, "entrypoints") + "/"
+	for key, value := range entries {
+		if strings.HasPrefix(key, prefix) {
+			entryPoints = append(entryPoints, value)
+		}
+	}
+	sort.Strings(entryPoints)
+	return entries[getRedisKey("http", "routers", router, "rule")] + "\x00" + strings.Join(entryPoints, ",")
+}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// httpRoute returns the keys of an HTTP router and its service
+func httpRoute(router, rule, service string, entryPoints ...string) map[string]string {
+	entries := map[string]string{
+		getRedisKey("http", "routers", router, "rule"):                                  rule,
+		getRedisKey("http", "routers", router, "service"):                               service,
+		getRedisKey("http", "services", service, "loadbalancer", "servers", "0", "url"): "http://" + service,
+	}
+	for i, entryPoint := range entryPoints {
+		entries[getRedisKey("http", "routers", router, "entrypoints", itoa(i))] = entryPoint
+	}
+	return entries
+}
+
+func TestMergeFailoverGroups(t *testing.T) {
+	group := []config.FailoverGroup{{Name: "app", Servers: []string{"a", "b", "c"}}}
+	rule := "Host(`app.example.com`)"
+
+	tests := []struct {
+		name     string
+		servers  map[string]map[string]string
+		want     []FailoverRoute
+		wantKeys map[string]string
+		gone     []string
+	}{
+		{
+			name: "two servers",
+			servers: map[string]map[string]string{
+				"a": httpRoute("app_a", rule, "a", "websecure"),
+				"b": httpRoute("app_b", rule, "b", "websecure"),
+			},
+			want: []FailoverRoute{{Group: "app", Router: "app_a", Service: "app_a_failover", Servers: []string{"a", "b"}}},
+			wantKeys: map[string]string{
+				"traefik/http/routers/app_a/service":                     "app_a_failover",
+				"traefik/http/services/app_a_failover/failover/service":  "a",
+				"traefik/http/services/app_a_failover/failover/fallback": "b",
+			},
+			gone: []string{"traefik/http/routers/app_b/rule"},
+		},
+		{
+			name: "three servers are chained",
+			servers: map[string]map[string]string{
+				"a": httpRoute("app_a", rule, "a", "websecure"),
+				"b": httpRoute("app_b", rule, "b", "websecure"),
+				"c": httpRoute("app_c", rule, "c", "websecure"),
+			},
+			want: []FailoverRoute{{Group: "app", Router: "app_a", Service: "app_a_failover", Servers: []string{"a", "b", "c"}}},
+			wantKeys: map[string]string{
+				"traefik/http/services/app_a_failover/failover/service":       "a",
+				"traefik/http/services/app_a_failover/failover/fallback":      "app_a_failover_2",
+				"traefik/http/services/app_a_failover_2/failover/service":     "b",
+				"traefik/http/services/app_a_failover_2/failover/fallback":    "c",
+				"traefik/http/services/app_a_failover_2/failover/healthcheck": "true",
+			},
+			gone: []string{"traefik/http/routers/app_b/rule", "traefik/http/routers/app_c/rule"},
+		},
+		{
+			name: "primary down",
+			servers: map[string]map[string]string{
+				"a": nil,
+				"b": httpRoute("app_b", rule, "b", "websecure"),
+				"c": httpRoute("app_c", rule, "c", "websecure"),
+			},
+			want: []FailoverRoute{{Group: "app", Router: "app_b", Service: "app_b_failover", Servers: []string{"b", "c"}}},
+			wantKeys: map[string]string{
+				"traefik/http/routers/app_b/service": "app_b_failover",
+			},
+		},
+		{
+			name: "different entrypoints",
+			servers: map[string]map[string]string{
+				"a": httpRoute("app_a", rule, "a", "websecure"),
+				"b": httpRoute("app_b", rule, "b", "web"),
+			},
+			wantKeys: map[string]string{
+				"traefik/http/routers/app_a/service": "a",
+				"traefik/http/routers/app_b/service": "b",
+			},
+		},
+		{
+			name: "different rules",
+			servers: map[string]map[string]string{
+				"a": httpRoute("app_a", rule, "a", "websecure"),
+				"b": httpRoute("app_b", "Host(`other.example.com`)", "b", "websecure"),
+			},
+			wantKeys: map[string]string{
+				"traefik/http/routers/app_a/service": "a",
+			},
+		},
+		{
+			name: "several routers of one server",
+			servers: map[string]map[string]string{
+				"a": mergeEntries(httpRoute("app_a", rule, "a", "websecure"), httpRoute("app2_a", rule, "a", "websecure")),
+				"b": httpRoute("app_b", rule, "b", "websecure"),
+			},
+			wantKeys: map[string]string{
+				"traefik/http/routers/app_a/service":  "a",
+				"traefik/http/routers/app2_a/service": "a",
+				"traefik/http/routers/app_b/service":  "b",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Retained entries of a server must never be changed
+			originals := make(map[string]map[string]string)
+			for server, entries := range tt.servers {
+				originals[server] = copyEntries(entries)
+			}
+			given := make(map[string]map[string]string)
+			for server, entries := range tt.servers {
+				given[server] = entries
+			}
+
+			got := mergeFailoverGroups(group, tt.servers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeFailoverGroups() = %+v, want %+v", got, tt.want)
+			}
+
+			merged := make(map[string]string)
+			for _, entries := range tt.servers {
+				for key, value := range entries {
+					merged[key] = value
+				}
+			}
+			for key, want := range tt.wantKeys {
+				if merged[key] != want {
+					t.Errorf("%s = %q, want %q", key, merged[key], want)
+				}
+			}
+			for _, key := range tt.gone {
+				if _, ok := merged[key]; ok {
+					t.Errorf("%s was not removed", key)
+				}
+			}
+			for server, entries := range given {
+				if entries != nil && !reflect.DeepEqual(entries, originals[server]) {
+					t.Errorf("entries of server '%s' were changed in place", server)
+				}
+			}
+		})
+	}
+}
+
+// mergeEntries returns the union of several sets of entries
+func mergeEntries(sets ...map[string]string) map[string]string {
+	entries := make(map[string]string)
+	for _, set := range sets {
+		for key, value := range set {
+			entries[key] = value
+		}
+	}
+	return entries
+}
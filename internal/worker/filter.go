@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/naming"
+	"github.com/hhftechnology/traefik-relay/internal/rule"
+)
+
+// skipReason returns why a router is not relayed, or an empty string when it
+// is. Routers Traefik disabled or reported errors for would fail on the main
+// instance as well.
+func (w *Worker) skipReason(server config.Server, target config.FilterTarget, errors []string) string {
+	if target.Status == "disabled" || len(errors) > 0 {
+		if len(errors) > 0 {
+			return "router has errors: " + strings.Join(errors, "; ")
+		}
+		return "router is disabled"
+	}
+	if server.GetServerOnlyEnabledRouters(w.config.OnlyEnabledRouters) && target.Status != "" && target.Status != "enabled" {
+		return fmt.Sprintf("router status is '%s'", target.Status)
+	}
+
+	optIn := server.GetServerOptIn(w.config.OptIn)
+	name, _ := naming.SplitName(target.Name)
+	if !optIn.Allows(name, target.Provider, target.Middlewares) {
+		return "router has no opt-in marker"
+	}
+
+	if !w.config.Filters.Allows(target) || !server.Filters.Allows(target) {
+		return "router is excluded by filters"
+	}
+	return ""
+}
+
+// filterTarget returns the data filters match a router against
+func filterTarget(name, provider, status, rule string, entryPoints, middlewares []string) config.FilterTarget {
+	if provider == "" {
+		_, provider = naming.SplitName(name)
+	}
+	return config.FilterTarget{
+		Name:        name,
+		Provider:    provider,
+		Status:      status,
+		Hosts:       ruleHosts(rule),
+		EntryPoints: entryPoints,
+		Middlewares: middlewares,
+	}
+}
+
+// ruleHosts returns the hosts a rule matches on, including HostRegexp patterns
+func ruleHosts(input string) []string {
+	expr, err := rule.Parse(input)
+	if err != nil {
+		return nil
+	}
+
+	var hosts []string
+	rule.Transform(expr, func(m *rule.Matcher) rule.Expr {
+		name := strings.ToLower(m.Name)
+		if hostMatcherNames[name] || name == "hostregexp" {
+			hosts = append(hosts, m.Args...)
+		}
+		return m
+	})
+	return hosts
+}
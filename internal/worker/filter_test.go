@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+// relayedRouters returns the sorted names of the HTTP routers in entries
+func relayedRouters(entries map[string]string) []string {
+	var routers []string
+	for key := range entries {
+		if name, ok := strings.CutPrefix(key, "traefik/http/routers/"); ok && strings.HasSuffix(name, "/rule") {
+			routers = append(routers, strings.TrimSuffix(name, "/rule"))
+		}
+	}
+	sort.Strings(routers)
+	return routers
+}
+
+func TestFilters(t *testing.T) {
+	fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{
+		{Name: "whoami@docker", EntryPoints: []string{"websecure"}, Rule: "Host(`whoami.lan`)"},
+		{Name: "admin@docker", EntryPoints: []string{"websecure"}, Rule: "Host(`admin.internal`)"},
+		{Name: "api@file", EntryPoints: []string{"web"}, Rule: "Host(`api.lan`) && PathPrefix(`/v1`)"},
+	}}
+
+	tests := []struct {
+		name    string
+		global  string
+		server  string
+		want    []string
+		skipped []string
+	}{
+		{
+			name: "no filters",
+			want: []string{"admin_compute-1", "api_compute-1", "whoami_compute-1"},
+		},
+		{
+			name:    "host excluded",
+			global:  "{exclude: [{host: '\\.internal$'}]}",
+			want:    []string{"api_compute-1", "whoami_compute-1"},
+			skipped: []string{"admin@docker"},
+		},
+		{
+			name:    "global and server filters",
+			global:  "{exclude: [{name: '^admin'}]}",
+			server:  "{include: [{provider: '^docker$'}]}",
+			want:    []string{"whoami_compute-1"},
+			skipped: []string{"admin@docker", "api@file"},
+		},
+		{
+			name:    "entrypoint included",
+			server:  "{include: [{entryPoint: '^web$'}]}",
+			want:    []string{"api_compute-1"},
+			skipped: []string{"admin@docker", "whoami@docker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      websecure: websecure
+      web: web
+`
+			if tt.server != "" {
+				content += "    filters: " + tt.server + "\n"
+			}
+			if tt.global != "" {
+				content += "filters: " + tt.global + "\n"
+			}
+
+			entries, report := processFake(t, fake, content)
+			if got := relayedRouters(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relayed routers = %v, want %v", got, tt.want)
+			}
+			reasons := skipReasons(report)
+			if len(reasons) != len(tt.skipped) {
+				t.Errorf("skipped routers = %v, want %v", reasons, tt.skipped)
+			}
+			for _, router := range tt.skipped {
+				if reason := reasons["http/"+router]; reason != "router is excluded by filters" {
+					t.Errorf("skip reason of %s = %q", router, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestOptIn(t *testing.T) {
+	fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{
+		{Name: "whoami@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`)", Middlewares: []string{"relay@file", "auth@file"}},
+		{Name: "blog-public@docker", EntryPoints: []string{"web"}, Rule: "Host(`blog.lan`)"},
+		{Name: "shop@kubernetescrd", EntryPoints: []string{"web"}, Rule: "Host(`shop.lan`)"},
+		{Name: "admin@docker", EntryPoints: []string{"web"}, Rule: "Host(`admin.lan`)", Middlewares: []string{"auth@file"}},
+	}}
+	content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    forwardMiddlewares: true
+    entryPoints:
+      web: web
+optIn:
+  enabled: true
+  middleware: relay@file
+  nameSuffix: -public
+  providers: [kubernetesCRD]
+`
+
+	entries, report := processFake(t, fake, content)
+	want := []string{"blog-public_compute-1", "shop_compute-1", "whoami_compute-1"}
+	if got := relayedRouters(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("relayed routers = %v, want %v", got, want)
+	}
+	if reason := skipReasons(report)["http/admin@docker"]; reason != "router has no opt-in marker" {
+		t.Errorf("skip reason of admin@docker = %q", reason)
+	}
+
+	// The marker middleware is not forwarded to the main instance
+	middlewares := keysBelow(entries, "traefik/http/routers/whoami_compute-1/middlewares/")
+	if want := map[string]string{"1": "auth@file"}; !reflect.DeepEqual(middlewares, want) {
+		t.Errorf("forwarded middlewares = %v, want %v", middlewares, want)
+	}
+}
+
+func TestSkipStatus(t *testing.T) {
+	fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{
+		{Name: "whoami@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`)", Status: "enabled"},
+		{Name: "legacy@docker", EntryPoints: []string{"web"}, Rule: "Host(`legacy.lan`)", Status: "warning"},
+		{Name: "stopped@docker", EntryPoints: []string{"web"}, Rule: "Host(`stopped.lan`)", Status: "disabled"},
+		{Name: "broken@docker", EntryPoints: []string{"web"}, Rule: "Host(`broken.lan`)", Status: "disabled", Error: []string{"middleware \"auth@file\" does not exist", "unknown TLS options"}},
+	}}
+
+	tests := []struct {
+		name    string
+		config  string
+		want    []string
+		skipped map[string]string
+	}{
+		{
+			name: "disabled and errored routers",
+			want: []string{"legacy_compute-1", "whoami_compute-1"},
+			skipped: map[string]string{
+				"http/stopped@docker": "router is disabled",
+				"http/broken@docker":  "router has errors: middleware \"auth@file\" does not exist; unknown TLS options",
+			},
+		},
+		{
+			name:   "only enabled routers",
+			config: "onlyEnabledRouters: true\n",
+			want:   []string{"whoami_compute-1"},
+			skipped: map[string]string{
+				"http/legacy@docker":  "router status is 'warning'",
+				"http/stopped@docker": "router is disabled",
+				"http/broken@docker":  "router has errors: middleware \"auth@file\" does not exist; unknown TLS options",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      web: web
+` + tt.config
+
+			entries, report := processFake(t, fake, content)
+			if got := relayedRouters(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relayed routers = %v, want %v", got, tt.want)
+			}
+			if got := skipReasons(report); !reflect.DeepEqual(got, tt.skipped) {
+				t.Errorf("skip reasons = %v, want %v", got, tt.skipped)
+			}
+		})
+	}
+}
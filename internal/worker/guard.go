@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PendingChange is a run held back because it would remove too much at once
+type PendingChange struct {
+	Detected       time.Time `json:"detected"`
+	Reason         string    `json:"reason"`
+	RemovedRouters []string  `json:"removedRouters"`
+	RemovedKeys    int       `json:"removedKeys"`
+	RemovedPercent float64   `json:"removedPercent"`
+	Approved       bool      `json:"approved"`
+}
+
+// checkDeletionGuard holds back a run whose removals exceed the configured
+// thresholds, unless the removals were approved through ApprovePending
+func (w *Worker) checkDeletionGuard(old, entries map[string]string, removed []string) error {
+	guard := w.config.DeletionGuard
+	if guard.MaxRouters <= 0 && guard.MaxPercent <= 0 {
+		return nil
+	}
+
+	removedRouters := removedRouterNames(old, entries)
+	var percent float64
+	if len(old) > 0 {
+		percent = float64(len(removed)) * 100 / float64(len(old))
+	}
+
+	var reason string
+	switch {
+	case guard.MaxRouters > 0 && len(removedRouters) > guard.MaxRouters:
+		reason = fmt.Sprintf("run would remove %d routers, more than the limit of %d", len(removedRouters), guard.MaxRouters)
+	case guard.MaxPercent > 0 && percent > guard.MaxPercent:
+		reason = fmt.Sprintf("run would remove %.1f%% of the published keys, more than the limit of %.1f%%", percent, guard.MaxPercent)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if reason == "" {
+		w.pending = nil
+		return nil
+	}
+
+	// An approval covers the run when it removes no router beyond the approved ones
+	if w.pending != nil && w.pending.Approved && isSubset(removedRouters, w.pending.RemovedRouters) {
+		log.Printf("Applying approved removal of %d routers", len(removedRouters))
+		w.pending = nil
+		return nil
+	}
+
+	// Only alert once for a change that stays pending across runs
+	detected := time.Now()
+	if w.pending != nil && equalStrings(w.pending.RemovedRouters, removedRouters) {
+		detected = w.pending.Detected
+	} else {
+		log.Printf("WARNING: holding back run, %s. Approve it with POST /api/v1/pending/approve", reason)
+	}
+
+	w.pending = &PendingChange{
+		Detected:       detected,
+		Reason:         reason,
+		RemovedRouters: removedRouters,
+		RemovedKeys:    len(removed),
+		RemovedPercent: percent,
+	}
+
+	return fmt.Errorf("run held back: %s", reason)
+}
+
+// Pending returns the change currently held back, or nil
+func (w *Worker) Pending() *PendingChange {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.pending == nil {
+		return nil
+	}
+	pending := *w.pending
+	return &pending
+}
+
+// ApprovePending approves the change currently held back and requests a run
+// to apply it. It returns false when there is nothing to approve.
+func (w *Worker) ApprovePending() bool {
+	w.mu.Lock()
+	if w.pending == nil {
+		w.mu.Unlock()
+		return false
+	}
+	w.pending.Approved = true
+	w.mu.Unlock()
+
+	w.RequestRun()
+	return true
+}
+
+// removedRouterNames returns the routers (e.g. "http/whoami_compute-1") that
+// are present in old but entirely absent from entries
+func removedRouterNames(old, entries map[string]string) []string {
+	routers := func(m map[string]string) map[string]bool {
+		names := make(map[string]bool)
+		for key := range m {
+			segments := strings.SplitN(key, "/", 5)
+			if len(segments) >= 4 && segments[2] == "routers" {
+				names[segments[1]+"/"+segments[3]] = true
+			}
+		}
+		return names
+	}
+
+	current := routers(entries)
+	removed := make([]string, 0)
+	for name := range routers(old) {
+		if !current[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	return removed
+}
+
+// isSubset reports whether every element of a is in b
+func isSubset(a, b []string) bool {
+	set := make(map[string]bool, len(b))
+	for _, item := range b {
+		set[item] = true
+	}
+	for _, item := range a {
+		if !set[item] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalStrings reports whether two sorted slices hold the same elements
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// routerEntries returns the keys of HTTP routers with the given names
+func routerEntries(names ...string) map[string]string {
+	entries := make(map[string]string)
+	for _, name := range names {
+		entries[getRedisKey("http", "routers", name, "rule")] = "Host(`" + name + "`)"
+		entries[getRedisKey("http", "routers", name, "service")] = "app"
+	}
+	return entries
+}
+
+func TestCheckDeletionGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		guard   config.DeletionGuard
+		old     map[string]string
+		entries map[string]string
+		wantErr bool
+		removed []string
+	}{
+		{
+			name:    "disabled",
+			old:     routerEntries("a", "b", "c"),
+			entries: routerEntries(),
+		},
+		{
+			name:    "routers within limit",
+			guard:   config.DeletionGuard{MaxRouters: 2},
+			old:     routerEntries("a", "b", "c"),
+			entries: routerEntries("a"),
+		},
+		{
+			name:    "routers over limit",
+			guard:   config.DeletionGuard{MaxRouters: 1},
+			old:     routerEntries("a", "b", "c"),
+			entries: routerEntries("a"),
+			wantErr: true,
+			removed: []string{"http/b", "http/c"},
+		},
+		{
+			name:    "percent within limit",
+			guard:   config.DeletionGuard{MaxPercent: 50},
+			old:     routerEntries("a", "b"),
+			entries: routerEntries("a"),
+		},
+		{
+			name:    "percent over limit",
+			guard:   config.DeletionGuard{MaxPercent: 40},
+			old:     routerEntries("a", "b"),
+			entries: routerEntries("a"),
+			wantErr: true,
+			removed: []string{"http/b"},
+		},
+		{
+			name:    "renamed router counts as removed",
+			guard:   config.DeletionGuard{MaxRouters: 0, MaxPercent: 10},
+			old:     routerEntries("a"),
+			entries: routerEntries("b"),
+			wantErr: true,
+			removed: []string{"http/a"},
+		},
+		{
+			name:    "first run",
+			guard:   config.DeletionGuard{MaxRouters: 1, MaxPercent: 10},
+			old:     map[string]string{},
+			entries: routerEntries("a", "b"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := New(&config.Config{DeletionGuard: tt.guard}, nil)
+			changes := computeDiff(tt.old, tt.entries)
+
+			err := w.checkDeletionGuard(tt.old, tt.entries, changes.removed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDeletionGuard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			pending := w.Pending()
+			if !tt.wantErr {
+				if pending != nil {
+					t.Errorf("Pending() = %+v, want nil", pending)
+				}
+				return
+			}
+			if pending == nil {
+				t.Fatal("Pending() = nil, want a held back change")
+			}
+			if !reflect.DeepEqual(pending.RemovedRouters, tt.removed) {
+				t.Errorf("RemovedRouters = %v, want %v", pending.RemovedRouters, tt.removed)
+			}
+		})
+	}
+}
+
+func TestDeletionGuardApproval(t *testing.T) {
+	w := New(&config.Config{DeletionGuard: config.DeletionGuard{MaxRouters: 1}}, nil)
+	old := routerEntries("a", "b", "c")
+
+	check := func(entries map[string]string) error {
+		return w.checkDeletionGuard(old, entries, computeDiff(old, entries).removed)
+	}
+
+	if err := check(routerEntries("a")); err == nil {
+		t.Fatal("run removing two routers was not held back")
+	}
+	detected := w.Pending().Detected
+
+	// The same change stays pending without being detected again
+	if err := check(routerEntries("a")); err == nil {
+		t.Fatal("run removing two routers was not held back")
+	}
+	if !w.Pending().Detected.Equal(detected) {
+		t.Error("pending change was detected again")
+	}
+
+	if !w.ApprovePending() {
+		t.Fatal("ApprovePending() = false, want true")
+	}
+
+	// An approval does not cover routers it did not list
+	if err := check(routerEntries()); err == nil {
+		t.Fatal("run removing an unapproved router was not held back")
+	}
+	if !w.ApprovePending() {
+		t.Fatal("ApprovePending() = false, want true")
+	}
+
+	if err := check(routerEntries()); err != nil {
+		t.Fatalf("approved run was held back: %v", err)
+	}
+	if pending := w.Pending(); pending != nil {
+		t.Errorf("Pending() = %+v after the approved run, want nil", pending)
+	}
+	if w.ApprovePending() {
+		t.Error("ApprovePending() = true without a pending change")
+	}
+}
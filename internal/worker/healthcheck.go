@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// putHTTPHealthCheck writes the health check of a generated HTTP service
+func putHTTPHealthCheck(entries map[string]string, service string, hc *config.HealthCheck) {
+	if hc == nil || hc.Path == "" {
+		return
+	}
+
+	key := func(segments ...string) string {
+		return getRedisKey(append([]string{"http", "services", service, "loadbalancer", "healthcheck"}, segments...)...)
+	}
+
+	entries[key("path")] = hc.Path
+	putOptional(entries, key("scheme"), hc.Scheme)
+	putOptional(entries, key("method"), hc.Method)
+	putOptional(entries, key("hostname"), hc.Hostname)
+	putSeconds(entries, key("interval"), hc.Interval)
+	putSeconds(entries, key("timeout"), hc.Timeout)
+	if hc.Port > 0 {
+		entries[key("port")] = itoa(hc.Port)
+	}
+	if hc.Status > 0 {
+		entries[key("status")] = itoa(hc.Status)
+	}
+	if hc.FollowRedirects != nil {
+		entries[key("followredirects")] = strconv.FormatBool(*hc.FollowRedirects)
+	}
+	for name, value := range hc.Headers {
+		entries[key("headers", name)] = value
+	}
+}
+
+// putTCPHealthCheck writes the health check of a generated TCP service
+func putTCPHealthCheck(entries map[string]string, service string, hc *config.HealthCheck) {
+	if hc == nil || !hc.TCP {
+		return
+	}
+
+	key := func(segments ...string) string {
+		return getRedisKey(append([]string{"tcp", "services", service, "loadbalancer", "healthcheck"}, segments...)...)
+	}
+
+	putSeconds(entries, key("interval"), hc.Interval)
+	putSeconds(entries, key("timeout"), hc.Timeout)
+	putOptional(entries, key("send"), hc.Send)
+	putOptional(entries, key("expect"), hc.Expect)
+
+	// Traefik needs at least one option to enable the health check
+	if hc.Interval == 0 && hc.Timeout == 0 && hc.Send == "" && hc.Expect == "" {
+		entries[key("interval")] = "30s"
+	}
+}
+
+// putOptional writes value unless it is empty
+func putOptional(entries map[string]string, key, value string) {
+	if value != "" {
+		entries[key] = value
+	}
+}
+
+// putSeconds writes a duration given in seconds unless it is zero
+func putSeconds(entries map[string]string, key string, seconds int) {
+	if seconds > 0 {
+		entries[key] = itoa(seconds) + "s"
+	}
+}
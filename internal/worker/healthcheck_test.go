@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+func TestPutHTTPHealthCheck(t *testing.T) {
+	followRedirects := false
+
+	tests := []struct {
+		name string
+		hc   *config.HealthCheck
+		want map[string]string
+	}{
+		{name: "none", want: map[string]string{}},
+		{name: "TCP only", hc: &config.HealthCheck{TCP: true}, want: map[string]string{}},
+		{
+			name: "path only",
+			hc:   &config.HealthCheck{Path: "/health"},
+			want: map[string]string{"path": "/health"},
+		},
+		{
+			name: "all options",
+			hc: &config.HealthCheck{
+				Path:            "/health",
+				Scheme:          "https",
+				Method:          "HEAD",
+				Hostname:        "whoami.lan",
+				Port:            8080,
+				Interval:        10,
+				Timeout:         3,
+				Status:          204,
+				Headers:         map[string]string{"X-Check": "relay"},
+				FollowRedirects: &followRedirects,
+			},
+			want: map[string]string{
+				"path":            "/health",
+				"scheme":          "https",
+				"method":          "HEAD",
+				"hostname":        "whoami.lan",
+				"port":            "8080",
+				"interval":        "10s",
+				"timeout":         "3s",
+				"status":          "204",
+				"headers/X-Check": "relay",
+				"followredirects": "false",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make(map[string]string)
+			putHTTPHealthCheck(entries, "compute-1", tt.hc)
+			got := keysBelow(entries, "traefik/http/services/compute-1/loadbalancer/healthcheck/")
+			if len(got) != len(entries) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("health check entries = %v, want %v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutTCPHealthCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		hc   *config.HealthCheck
+		want map[string]string
+	}{
+		{name: "none", want: map[string]string{}},
+		{name: "HTTP only", hc: &config.HealthCheck{Path: "/health"}, want: map[string]string{}},
+		{
+			name: "defaults",
+			hc:   &config.HealthCheck{TCP: true},
+			want: map[string]string{"interval": "30s"},
+		},
+		{
+			name: "all options",
+			hc:   &config.HealthCheck{TCP: true, Interval: 5, Timeout: 2, Send: "PING", Expect: "PONG"},
+			want: map[string]string{"interval": "5s", "timeout": "2s", "send": "PING", "expect": "PONG"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make(map[string]string)
+			putTCPHealthCheck(entries, "compute-1_postgres", tt.hc)
+			got := keysBelow(entries, "traefik/tcp/services/compute-1_postgres/loadbalancer/healthcheck/")
+			if len(got) != len(entries) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("health check entries = %v, want %v", entries, tt.want)
+			}
+		})
+	}
+}
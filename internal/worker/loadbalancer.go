@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// putLoadBalancer writes the load balancer options of a generated HTTP service
+func putLoadBalancer(entries map[string]string, service string, lb *config.LoadBalancer) {
+	if lb == nil {
+		return
+	}
+
+	key := func(segments ...string) string {
+		return getRedisKey(append([]string{"http", "services", service, "loadbalancer"}, segments...)...)
+	}
+
+	if lb.PassHostHeader != nil {
+		entries[key("passhostheader")] = strconv.FormatBool(*lb.PassHostHeader)
+	}
+	putOptional(entries, key("serverstransport"), lb.ServersTransport)
+	if lb.FlushInterval != 0 {
+		entries[key("responseforwarding", "flushinterval")] = itoa(lb.FlushInterval) + "ms"
+	}
+
+	cookie := lb.StickyCookie
+	if cookie == nil {
+		return
+	}
+	if *cookie == (config.StickyCookie{}) {
+		// A cookie without options is enabled with Traefik's defaults
+		entries[key("sticky", "cookie")] = "true"
+		return
+	}
+	putOptional(entries, key("sticky", "cookie", "name"), cookie.Name)
+	putOptional(entries, key("sticky", "cookie", "samesite"), cookie.SameSite)
+	if cookie.Secure {
+		entries[key("sticky", "cookie", "secure")] = "true"
+	}
+	if cookie.HTTPOnly {
+		entries[key("sticky", "cookie", "httponly")] = "true"
+	}
+	if cookie.MaxAge != 0 {
+		entries[key("sticky", "cookie", "maxage")] = itoa(cookie.MaxAge)
+	}
+}
@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+func TestPutLoadBalancer(t *testing.T) {
+	passHostHeader := false
+
+	tests := []struct {
+		name string
+		lb   *config.LoadBalancer
+		want map[string]string
+	}{
+		{name: "none", want: map[string]string{}},
+		{
+			name: "transport options",
+			lb:   &config.LoadBalancer{PassHostHeader: &passHostHeader, ServersTransport: "insecure@file", FlushInterval: 100},
+			want: map[string]string{
+				"passhostheader":                   "false",
+				"serverstransport":                 "insecure@file",
+				"responseforwarding/flushinterval": "100ms",
+			},
+		},
+		{
+			name: "sticky cookie with defaults",
+			lb:   &config.LoadBalancer{StickyCookie: &config.StickyCookie{}},
+			want: map[string]string{"sticky/cookie": "true"},
+		},
+		{
+			name: "sticky cookie with options",
+			lb: &config.LoadBalancer{StickyCookie: &config.StickyCookie{
+				Name:     "relay",
+				Secure:   true,
+				HTTPOnly: true,
+				SameSite: "lax",
+				MaxAge:   3600,
+			}},
+			want: map[string]string{
+				"sticky/cookie/name":     "relay",
+				"sticky/cookie/secure":   "true",
+				"sticky/cookie/httponly": "true",
+				"sticky/cookie/samesite": "lax",
+				"sticky/cookie/maxage":   "3600",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make(map[string]string)
+			putLoadBalancer(entries, "compute-1", tt.lb)
+			got := keysBelow(entries, "traefik/http/services/compute-1/loadbalancer/")
+			if len(got) != len(entries) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("load balancer entries = %v, want %v", entries, tt.want)
+			}
+		})
+	}
+}
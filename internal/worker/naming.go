@@ -0,0 +1,224 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/naming"
+)
+
+// Collision describes a router or service name generated for several objects.
+// None of them is published, since either one would silently replace the others.
+type Collision struct {
+	Protocol string   `json:"protocol"`
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	Servers  []string `json:"servers"`
+	Routers  []string `json:"routers,omitempty"`
+}
+
+// namer generates the names the routers and services of a server are published under
+type namer struct {
+	server  string
+	router  *naming.Template
+	service *naming.Template
+
+	// Whether the templates already give every entrypoint its own name
+	routerPerEntryPoint  bool
+	servicePerEntryPoint bool
+}
+
+// newNamer compiles the name templates of a server
+func newNamer(cfg *config.Config, server config.Server) (*namer, error) {
+	router, err := naming.Parse(server.GetServerRouterNameTemplate(cfg.RouterNameTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid router name template: %w", err)
+	}
+
+	n := &namer{server: server.Name, router: router}
+	n.routerPerEntryPoint = router.DependsOn(varyEntryPoint)
+
+	if text := server.GetServerServiceNameTemplate(cfg.ServiceNameTemplate); text != "" {
+		if n.service, err = naming.Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid service name template: %w", err)
+		}
+		n.servicePerEntryPoint = n.service.DependsOn(varyEntryPoint)
+	}
+
+	return n, nil
+}
+
+// varyEntryPoint sets the entrypoint of template fields
+func varyEntryPoint(fields *naming.Fields, value string) {
+	fields.EntryPoint = value
+}
+
+// fields returns the template fields of a router published from localEP
+func (n *namer) fields(router, localEP string) naming.Fields {
+	name, provider := naming.SplitName(router)
+	return naming.Fields{Router: name, Provider: provider, Server: n.server, EntryPoint: localEP}
+}
+
+// routerName returns the name of a router published from localEP. split is
+// set when the router is published once per local entrypoint.
+func (n *namer) routerName(router, localEP string, split bool) (string, error) {
+	name, err := n.router.Execute(n.fields(router, localEP))
+	if err != nil {
+		return "", err
+	}
+	if split && !n.routerPerEntryPoint {
+		name += "_" + localEP
+	}
+	return name, nil
+}
+
+// serviceName returns the name of the service a router published from localEP
+// points at. TCP and UDP services need one service per port.
+func (n *namer) serviceName(router, localEP string, perPort bool) (string, error) {
+	tmpl := n.service
+	if tmpl == nil {
+		if perPort {
+			return n.server + "_" + localEP, nil
+		}
+		return n.server, nil
+	}
+
+	name, err := tmpl.Execute(n.fields(router, localEP))
+	if err != nil {
+		return "", err
+	}
+	if perPort && !n.servicePerEntryPoint {
+		name += "_" + localEP
+	}
+	return name, nil
+}
+
+// routerSources maps every router published by a server (e.g. "http/whoami_compute-1")
+// to the local routers it was generated from
+type routerSources map[string][]string
+
+// add records that router is published as name
+func (s routerSources) add(protocol, name, router string) {
+	key := protocol + "/" + name
+	s[key] = append(s[key], router)
+}
+
+// dropCollisions removes the routers of a server generated from more than one
+// local router from entries
+func (s routerSources) dropCollisions(server string, entries map[string]string) []Collision {
+	var collisions []Collision
+	for key, routers := range s {
+		if len(routers) < 2 {
+			continue
+		}
+		protocol, name, _ := strings.Cut(key, "/")
+		sort.Strings(routers)
+		collisions = append(collisions, Collision{
+			Protocol: protocol,
+			Kind:     "routers",
+			Name:     name,
+			Servers:  []string{server},
+			Routers:  routers,
+		})
+		deleteRouter(entries, protocol, name)
+	}
+	return sortCollisions(collisions)
+}
+
+// dropSharedObjects removes the routers and services published by more than
+// one server from entries, together with the routers using such a service.
+// serverEntries holds the entries of every server.
+func dropSharedObjects(serverEntries map[string]map[string]string, entries map[string]string) []Collision {
+	publishers := make(map[string][]string)
+	for server, serverKeys := range serverEntries {
+		seen := make(map[string]bool)
+		for key := range serverKeys {
+			segments := strings.SplitN(key, "/", 5)
+			if len(segments) < 4 || (segments[2] != "routers" && segments[2] != "services") {
+				continue
+			}
+			object := strings.Join(segments[1:4], "/")
+			if !seen[object] {
+				seen[object] = true
+				publishers[object] = append(publishers[object], server)
+			}
+		}
+	}
+
+	var collisions []Collision
+	for object, servers := range publishers {
+		if len(servers) < 2 {
+			continue
+		}
+		segments := strings.Split(object, "/")
+		protocol, kind, name := segments[0], segments[1], segments[2]
+		sort.Strings(servers)
+		collisions = append(collisions, Collision{
+			Protocol: protocol,
+			Kind:     kind,
+			Name:     name,
+			Servers:  servers,
+		})
+
+		if kind == "routers" {
+			deleteRouter(entries, protocol, name)
+			continue
+		}
+
+		// Routers using the service would reach an arbitrary server
+		deleteObject(entries, protocol, kind, name)
+		for key, value := range entries {
+			router := strings.Split(key, "/")
+			if len(router) == 5 && router[1] == protocol && router[2] == "routers" &&
+				router[4] == "service" && value == name {
+				deleteRouter(entries, protocol, router[3])
+			}
+		}
+	}
+	return sortCollisions(collisions)
+}
+
+// deleteRouter removes every key of a router from entries
+func deleteRouter(entries map[string]string, protocol, name string) {
+	deleteObject(entries, protocol, "routers", name)
+}
+
+// deleteObject removes every key of a router or service from entries
+func deleteObject(entries map[string]string, protocol, kind, name string) {
+	prefix := getRedisKey(protocol, kind, name) + "/"
+	for key := range entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(entries, key)
+		}
+	}
+}
+
+// sortCollisions orders collisions by protocol, kind and name and logs them
+func sortCollisions(collisions []Collision) []Collision {
+	sort.Slice(collisions, func(i, j int) bool {
+		a, b := collisions[i], collisions[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+
+	for _, collision := range collisions {
+		kind := strings.TrimSuffix(collision.Kind, "s")
+		if len(collision.Routers) > 0 {
+			log.Printf("Skipping %s %s '%s': generated for routers %s of server '%s'",
+				collision.Protocol, kind, collision.Name, strings.Join(collision.Routers, ", "), collision.Servers[0])
+		} else {
+			log.Printf("Skipping %s %s '%s': published by servers %s",
+				collision.Protocol, kind, collision.Name, strings.Join(collision.Servers, ", "))
+		}
+	}
+
+	return collisions
+}
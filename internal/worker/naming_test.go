@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/naming"
+)
+
+func TestNamer(t *testing.T) {
+	tests := []struct {
+		name            string
+		routerTemplate  string
+		serviceTemplate string
+		split           bool
+		perPort         bool
+		wantRouter      string
+		wantService     string
+	}{
+		{name: "defaults", wantRouter: "whoami_compute-1", wantService: "compute-1"},
+		{name: "split per entrypoint", split: true, perPort: true, wantRouter: "whoami_compute-1_web", wantService: "compute-1_web"},
+		{
+			name:           "template with entrypoint",
+			routerTemplate: "{{.Router}}-{{.EntryPoint}}",
+			split:          true,
+			wantRouter:     "whoami-web",
+			wantService:    "compute-1",
+		},
+		{
+			name:            "service template",
+			routerTemplate:  "{{.Provider}}-{{.Router}}",
+			serviceTemplate: "{{.Router}}_{{.Server}}",
+			wantRouter:      "docker-whoami",
+			wantService:     "whoami_compute-1",
+		},
+		{
+			name:            "service template per port",
+			serviceTemplate: "{{.Router}}",
+			perPort:         true,
+			wantRouter:      "whoami_compute-1",
+			wantService:     "whoami_web",
+		},
+		{
+			name:            "service template with entrypoint",
+			serviceTemplate: "{{.EntryPoint}}-{{.Server}}",
+			perPort:         true,
+			wantRouter:      "whoami_compute-1",
+			wantService:     "web-compute-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{RouterNameTemplate: naming.DefaultRouterTemplate}
+			server := config.Server{
+				Name:                "compute-1",
+				RouterNameTemplate:  tt.routerTemplate,
+				ServiceNameTemplate: tt.serviceTemplate,
+			}
+			names, err := newNamer(cfg, server)
+			if err != nil {
+				t.Fatalf("newNamer() error = %v", err)
+			}
+
+			router, err := names.routerName("whoami@docker", "web", tt.split)
+			if err != nil {
+				t.Fatalf("routerName() error = %v", err)
+			}
+			if router != tt.wantRouter {
+				t.Errorf("routerName() = %q, want %q", router, tt.wantRouter)
+			}
+
+			service, err := names.serviceName("whoami@docker", "web", tt.perPort)
+			if err != nil {
+				t.Fatalf("serviceName() error = %v", err)
+			}
+			if service != tt.wantService {
+				t.Errorf("serviceName() = %q, want %q", service, tt.wantService)
+			}
+		})
+	}
+}
+
+func TestDropCollisions(t *testing.T) {
+	sources := routerSources{}
+	sources.add("http", "whoami", "whoami@docker")
+	sources.add("http", "whoami", "whoami@file")
+	sources.add("http", "api", "api@docker")
+	entries := mergeEntries(httpRoute("whoami", "Host(`a`)", "s", "web"), httpRoute("api", "Host(`b`)", "s", "web"))
+
+	got := sources.dropCollisions("compute-1", entries)
+	want := []Collision{{
+		Protocol: "http",
+		Kind:     "routers",
+		Name:     "whoami",
+		Servers:  []string{"compute-1"},
+		Routers:  []string{"whoami@docker", "whoami@file"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dropCollisions() = %+v, want %+v", got, want)
+	}
+	if _, ok := entries["traefik/http/routers/whoami/rule"]; ok {
+		t.Error("colliding router was not removed")
+	}
+	if _, ok := entries["traefik/http/routers/api/rule"]; !ok {
+		t.Error("router without collision was removed")
+	}
+}
+
+func TestDropSharedObjects(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers map[string]map[string]string
+		want    []Collision
+		kept    []string
+		dropped []string
+	}{
+		{
+			name: "distinct names",
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami_a", "Host(`a`)", "a", "web"),
+				"b": httpRoute("whoami_b", "Host(`b`)", "b", "web"),
+			},
+			kept: []string{"traefik/http/routers/whoami_a/rule", "traefik/http/routers/whoami_b/rule"},
+		},
+		{
+			name: "shared router",
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami", "Host(`a`)", "a", "web"),
+				"b": httpRoute("whoami", "Host(`b`)", "b", "web"),
+			},
+			want: []Collision{{Protocol: "http", Kind: "routers", Name: "whoami", Servers: []string{"a", "b"}}},
+			kept: []string{"traefik/http/services/a/loadbalancer/servers/0/url"},
+			dropped: []string{
+				"traefik/http/routers/whoami/rule",
+				"traefik/http/routers/whoami/service",
+			},
+		},
+		{
+			name: "shared service",
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami_a", "Host(`a`)", "app", "web"),
+				"b": mergeEntries(httpRoute("whoami_b", "Host(`b`)", "app", "web"), httpRoute("api_b", "Host(`c`)", "b", "web")),
+			},
+			want: []Collision{{Protocol: "http", Kind: "services", Name: "app", Servers: []string{"a", "b"}}},
+			kept: []string{"traefik/http/routers/api_b/rule"},
+			dropped: []string{
+				"traefik/http/services/app/loadbalancer/servers/0/url",
+				"traefik/http/routers/whoami_a/rule",
+				"traefik/http/routers/whoami_b/rule",
+			},
+		},
+		{
+			name: "same name on other protocols",
+			servers: map[string]map[string]string{
+				"a": httpRoute("db", "Host(`a`)", "a", "web"),
+				"b": {"traefik/tcp/routers/db/rule": "HostSNI(`*`)"},
+			},
+			kept: []string{"traefik/http/routers/db/rule", "traefik/tcp/routers/db/rule"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := make(map[string]string)
+			for _, serverEntries := range tt.servers {
+				for key, value := range serverEntries {
+					entries[key] = value
+				}
+			}
+
+			got := dropSharedObjects(tt.servers, entries)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dropSharedObjects() = %+v, want %+v", got, tt.want)
+			}
+			for _, key := range tt.kept {
+				if _, ok := entries[key]; !ok {
+					t.Errorf("%s was removed", key)
+				}
+			}
+			for _, key := range tt.dropped {
+				if _, ok := entries[key]; ok {
+					t.Errorf("%s was not removed", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"sort"
+	"time"
+)
+
+// RunResult describes the outcome of a single run
+type RunResult struct {
+	Started    time.Time       `json:"started"`
+	Duration   string          `json:"duration"`
+	Entries    int             `json:"entries"`
+	Added      []string        `json:"added"`
+	Changed    []string        `json:"changed"`
+	Removed    []string        `json:"removed"`
+	Conflicts  []Conflict      `json:"conflicts"`
+	Collisions []Collision     `json:"collisions"`
+	Skipped    []SkippedRouter `json:"skipped"`
+	Failovers  []FailoverRoute `json:"failovers"`
+	Splits     []SplitRoute    `json:"splits"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// SkippedRouter describes a router of a server that is not relayed
+type SkippedRouter struct {
+	Server   string `json:"server"`
+	Protocol string `json:"protocol"`
+	Router   string `json:"router"`
+	Reason   string `json:"reason"`
+}
+
+// serverReport collects what happened to the routers of a server during a run
+type serverReport struct {
+	server     string
+	sources    routerSources
+	collisions []Collision
+	skipped    []SkippedRouter
+}
+
+// skip records a router that is not relayed
+func (r *serverReport) skip(protocol, router, reason string) {
+	r.skipped = append(r.skipped, SkippedRouter{
+		Server:   r.server,
+		Protocol: protocol,
+		Router:   router,
+		Reason:   reason,
+	})
+}
+
+// diff holds the changes between two sets of entries
+type diff struct {
+	added   []string
+	changed []string
+	removed []string
+}
+
+// computeDiff compares the entries of a run with the previous ones
+func computeDiff(old, entries map[string]string) diff {
+	d := diff{added: []string{}, changed: []string{}, removed: []string{}}
+	for key, value := range entries {
+		oldValue, exists := old[key]
+		if !exists {
+			d.added = append(d.added, key)
+		} else if oldValue != value {
+			d.changed = append(d.changed, key)
+		}
+	}
+	for key := range old {
+		if _, exists := entries[key]; !exists {
+			d.removed = append(d.removed, key)
+		}
+	}
+
+	sort.Strings(d.added)
+	sort.Strings(d.changed)
+	sort.Strings(d.removed)
+
+	return d
+}
+
+// empty reports whether there is nothing to publish
+func (d diff) empty() bool {
+	return len(d.added) == 0 && len(d.changed) == 0 && len(d.removed) == 0
+}
+
+// updated returns the added and changed entries
+func (d diff) updated(entries map[string]string) map[string]string {
+	updated := make(map[string]string, len(d.added)+len(d.changed))
+	for _, key := range d.added {
+		updated[key] = entries[key]
+	}
+	for _, key := range d.changed {
+		updated[key] = entries[key]
+	}
+	return updated
+}
+
+// copyEntries returns a shallow copy of entries
+func copyEntries(entries map[string]string) map[string]string {
+	result := make(map[string]string, len(entries))
+	for key, value := range entries {
+		result[key] = value
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// RetainStore keeps the last known good entries of every server, so that a
+// server that is unreachable when the relay restarts keeps its routes
+type RetainStore interface {
+	// LoadRetained returns the last known good entries, by server name
+	LoadRetained(ctx context.Context) (map[string]map[string]string, error)
+	// StoreRetained records the last known good entries of a server
+	StoreRetained(ctx context.Context, server string, entries map[string]string) error
+	// DeleteRetained forgets the last known good entries of a server
+	DeleteRetained(ctx context.Context, server string) error
+}
+
+// ServerState holds the relay state of a single server
+type ServerState struct {
+	Healthy     bool                `json:"healthy"`
+	Retained    bool                `json:"retained"`
+	Failures    int                 `json:"failures"`
+	LastSuccess time.Time           `json:"lastSuccess"`
+	LastError   string              `json:"lastError,omitempty"`
+	Policy      config.RetainPolicy `json:"policy"`
+	entries     map[string]string
+}
+
+// applyRetainPolicy records the outcome of processing a server and returns the
+// entries to publish for it. When the server failed, its last known good
+// entries are returned until the retain policy lets them go.
+func (w *Worker) applyRetainPolicy(server config.Server, entries map[string]string, err error) map[string]string {
+	policy := server.GetServerRetainPolicy(w.config.RetainOnError)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	state, ok := w.servers[server.Name]
+	if !ok {
+		state = &ServerState{}
+		w.servers[server.Name] = state
+	}
+	state.Policy = policy
+
+	if err == nil {
+		state.Healthy = true
+		state.Retained = false
+		state.Failures = 0
+		state.LastSuccess = time.Now()
+		state.LastError = ""
+		state.entries = entries
+		return entries
+	}
+
+	state.Healthy = false
+	state.Failures++
+	state.LastError = err.Error()
+
+	if state.entries == nil {
+		return nil
+	}
+
+	withinGrace := policy.GracePeriod < 0 ||
+		time.Since(state.LastSuccess) < time.Duration(policy.GracePeriod)*time.Second
+	if state.Failures < policy.Failures || withinGrace {
+		state.Retained = true
+		log.Printf("Keeping last known good configuration of server '%s' (%d consecutive failures)", server.Name, state.Failures)
+		return state.entries
+	}
+
+	log.Printf("Removing routes of server '%s' after %d consecutive failures", server.Name, state.Failures)
+	state.Retained = false
+	state.entries = nil
+	return nil
+}
+
+// ServerStates returns the relay state of every processed server
+func (w *Worker) ServerStates() map[string]ServerState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	states := make(map[string]ServerState, len(w.servers))
+	for name, state := range w.servers {
+		states[name] = *state
+	}
+	return states
+}
+
+// SetRetainStore makes the last known good entries of the servers survive
+// restarts. Without a store they are kept in memory only.
+func (w *Worker) SetRetainStore(store RetainStore) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.retainStore = store
+}
+
+// storeRetained records the last known good entries of the servers that
+// changed since they were last stored, and forgets those of servers without any
+func (w *Worker) storeRetained(ctx context.Context) error {
+	w.mu.RLock()
+	store := w.retainStore
+	current := make(map[string]map[string]string)
+	for name, state := range w.servers {
+		if state.entries != nil {
+			current[name] = state.entries
+		}
+	}
+	w.mu.RUnlock()
+
+	if store == nil {
+		return nil
+	}
+
+	for name, entries := range current {
+		if stored, ok := w.storedRetained[name]; ok && computeDiff(stored, entries).empty() {
+			continue
+		}
+		if err := store.StoreRetained(ctx, name, entries); err != nil {
+			return fmt.Errorf("error storing entries of server '%s': %w", name, err)
+		}
+		w.storedRetained[name] = entries
+	}
+
+	for name := range w.storedRetained {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if err := store.DeleteRetained(ctx, name); err != nil {
+			return fmt.Errorf("error deleting entries of server '%s': %w", name, err)
+		}
+		delete(w.storedRetained, name)
+	}
+
+	return nil
+}
+
+// loadRetained restores the last known good entries of the servers from the
+// retain store, if any
+func (w *Worker) loadRetained(ctx context.Context) error {
+	w.mu.RLock()
+	store := w.retainStore
+	w.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	retained, err := store.LoadRetained(ctx)
+	if err != nil {
+		return fmt.Errorf("error loading last known good entries: %w", err)
+	}
+
+	w.mu.Lock()
+	w.seedRetained(retained)
+	w.mu.Unlock()
+
+	// Entries of servers no longer configured are forgotten by the next run
+	w.storedRetained = retained
+	return nil
+}
+
+// seedRetained restores the last known good entries of the servers without
+// any, so that a server failing right after a restart keeps its routes. The
+// caller must hold w.mu.
+func (w *Worker) seedRetained(retained map[string]map[string]string) {
+	for _, server := range w.config.Servers {
+		state, ok := w.servers[server.Name]
+		if ok && state.entries != nil {
+			continue
+		}
+
+		entries := retained[server.Name]
+		if entries == nil {
+			continue
+		}
+
+		if !ok {
+			state = &ServerState{}
+			w.servers[server.Name] = state
+		}
+		// The time of the last success before the restart is unknown, so
+		// the grace period starts now
+		state.LastSuccess = time.Now()
+		state.entries = entries
+	}
+}
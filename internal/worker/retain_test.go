@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+func TestApplyRetainPolicy(t *testing.T) {
+	good := routerEntries("a")
+	failure := errors.New("connection refused")
+
+	// step is the outcome of processing the server in one run
+	type step struct {
+		entries map[string]string
+		err     error
+		want    map[string]string
+	}
+
+	tests := []struct {
+		name   string
+		policy config.RetainPolicy
+		steps  []step
+	}{
+		{
+			name:   "failure before any success",
+			policy: config.RetainPolicy{Failures: 3},
+			steps: []step{
+				{err: failure, want: nil},
+			},
+		},
+		{
+			name:   "kept until failures reached",
+			policy: config.RetainPolicy{Failures: 3},
+			steps: []step{
+				{entries: good, want: good},
+				{err: failure, want: good},
+				{err: failure, want: good},
+				{err: failure, want: nil},
+				{err: failure, want: nil},
+			},
+		},
+		{
+			name:   "removed on first failure",
+			policy: config.RetainPolicy{Failures: 1},
+			steps: []step{
+				{entries: good, want: good},
+				{err: failure, want: nil},
+			},
+		},
+		{
+			name:   "kept within grace period",
+			policy: config.RetainPolicy{Failures: 1, GracePeriod: 3600},
+			steps: []step{
+				{entries: good, want: good},
+				{err: failure, want: good},
+				{err: failure, want: good},
+			},
+		},
+		{
+			name:   "kept until recovery",
+			policy: config.RetainPolicy{Failures: 1, GracePeriod: -1},
+			steps: []step{
+				{entries: good, want: good},
+				{err: failure, want: good},
+				{entries: routerEntries("b"), want: routerEntries("b")},
+			},
+		},
+		{
+			name:   "recovery resets failures",
+			policy: config.RetainPolicy{Failures: 2},
+			steps: []step{
+				{entries: good, want: good},
+				{err: failure, want: good},
+				{entries: good, want: good},
+				{err: failure, want: good},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := config.Server{Name: "compute-1"}
+			w := New(&config.Config{Servers: []config.Server{server}, RetainOnError: tt.policy}, nil)
+
+			for i, step := range tt.steps {
+				got := w.applyRetainPolicy(server, step.entries, step.err)
+				if !reflect.DeepEqual(got, step.want) {
+					t.Fatalf("run %d: applyRetainPolicy() = %v, want %v", i+1, got, step.want)
+				}
+
+				state := w.ServerStates()[server.Name]
+				if state.Healthy != (step.err == nil) {
+					t.Errorf("run %d: Healthy = %v", i+1, state.Healthy)
+				}
+				if state.Retained != (step.err != nil && step.want != nil) {
+					t.Errorf("run %d: Retained = %v", i+1, state.Retained)
+				}
+			}
+		})
+	}
+}
+
+// memoryRetainStore is a RetainStore keeping entries in a map
+type memoryRetainStore struct {
+	retained map[string]map[string]string
+	stores   int
+}
+
+func (s *memoryRetainStore) LoadRetained(ctx context.Context) (map[string]map[string]string, error) {
+	retained := make(map[string]map[string]string, len(s.retained))
+	for server, entries := range s.retained {
+		retained[server] = entries
+	}
+	return retained, nil
+}
+
+func (s *memoryRetainStore) StoreRetained(ctx context.Context, server string, entries map[string]string) error {
+	s.stores++
+	s.retained[server] = entries
+	return nil
+}
+
+func (s *memoryRetainStore) DeleteRetained(ctx context.Context, server string) error {
+	delete(s.retained, server)
+	return nil
+}
+
+func TestRetainStore(t *testing.T) {
+	ctx := context.Background()
+	servers := []config.Server{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	cfg := &config.Config{
+		InstanceID:    "office",
+		Servers:       servers,
+		RetainOnError: config.RetainPolicy{Failures: 2},
+	}
+	store := &memoryRetainStore{retained: map[string]map[string]string{
+		"removed": routerEntries("whoami_removed"),
+	}}
+
+	// A first process records the entries of its servers and forgets those
+	// of servers no longer configured
+	before := New(cfg, nil)
+	before.SetRetainStore(store)
+	if err := before.loadRetained(ctx); err != nil {
+		t.Fatalf("loadRetained() error = %v", err)
+	}
+	before.applyRetainPolicy(servers[0], routerEntries("whoami_a"), nil)
+	before.applyRetainPolicy(servers[1], routerEntries("whoami_b"), nil)
+	before.applyRetainPolicy(servers[2], nil, errors.New("unreachable"))
+	if err := before.storeRetained(ctx); err != nil {
+		t.Fatalf("storeRetained() error = %v", err)
+	}
+	want := map[string]map[string]string{"a": routerEntries("whoami_a"), "b": routerEntries("whoami_b")}
+	if !reflect.DeepEqual(store.retained, want) {
+		t.Fatalf("stored entries = %v, want the entries of servers a and b", store.retained)
+	}
+
+	// Unchanged entries are not stored again
+	stores := store.stores
+	before.applyRetainPolicy(servers[0], routerEntries("whoami_a"), nil)
+	if err := before.storeRetained(ctx); err != nil {
+		t.Fatalf("storeRetained() error = %v", err)
+	}
+	if store.stores != stores {
+		t.Errorf("unchanged entries stored %d more times", store.stores-stores)
+	}
+
+	// The next process restores them, keeping state it already has
+	after := New(cfg, nil)
+	after.SetRetainStore(store)
+	after.applyRetainPolicy(servers[1], routerEntries("whoami_b2"), nil)
+	if err := after.loadRetained(ctx); err != nil {
+		t.Fatalf("loadRetained() error = %v", err)
+	}
+
+	failure := errors.New("connection refused")
+	tests := []struct {
+		server config.Server
+		want   map[string]string
+	}{
+		{servers[0], routerEntries("whoami_a")},
+		{servers[1], routerEntries("whoami_b2")},
+		{servers[2], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.server.Name, func(t *testing.T) {
+			got := after.applyRetainPolicy(tt.server, nil, failure)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyRetainPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	state := after.ServerStates()["a"]
+	if !state.Retained || time.Since(state.LastSuccess) > time.Minute {
+		t.Errorf("state of seeded server = %+v, want retained with a recent last success", state)
+	}
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/rule"
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+// hostMatcherNames are the matchers whose arguments are hosts
+var hostMatcherNames = map[string]bool{
+	"host":       true,
+	"hostheader": true,
+	"hostsni":    true,
+}
+
+// rewriteRule applies the rule rewrites of a server to a router rule. Rules
+// are only parsed and printed again when the server has rewrites.
+func rewriteRule(rewrite *config.Rewrite, input string) (string, error) {
+	if rewrite == nil {
+		return input, nil
+	}
+
+	expr, err := rule.Parse(input)
+	if err != nil {
+		return "", err
+	}
+
+	var extraHostsAdded bool
+	expr = rule.Transform(expr, func(m *rule.Matcher) rule.Expr {
+		name := strings.ToLower(m.Name)
+		isHost := hostMatcherNames[name]
+		isHostRegexp := name == "hostregexp"
+
+		result := &rule.Matcher{Name: m.Name, Args: make([]string, len(m.Args))}
+		for i, arg := range m.Args {
+			switch {
+			case isHost && arg != "*":
+				arg = mapDomain(rewrite.Domains, arg)
+			case isHostRegexp:
+				arg = mapDomainRegexp(rewrite.Domains, arg)
+			}
+			result.Args[i] = replaceArg(rewrite, m.Name, isHost || isHostRegexp, arg)
+		}
+
+		// Serve the extra hosts alongside the first host matcher
+		if extraHostsAdded || len(rewrite.ExtraHosts) == 0 || (name != "host" && name != "hostsni") ||
+			(len(m.Args) == 1 && m.Args[0] == "*") {
+			return result
+		}
+		extraHostsAdded = true
+		var expr rule.Expr = result
+		for _, host := range rewrite.ExtraHosts {
+			expr = &rule.Or{Left: expr, Right: &rule.Matcher{Name: m.Name, Args: []string{host}}}
+		}
+		return expr
+	})
+
+	return rule.String(expr), nil
+}
+
+// rewriteTLS applies the domain mappings and host replacements of a server to
+// the domains of TLS settings, so that certificates are requested for the
+// published hosts rather than the local ones
+func rewriteTLS(rewrite *config.Rewrite, tls *traefik.TLS) *traefik.TLS {
+	if rewrite == nil || tls == nil || len(tls.Domains) == 0 {
+		return tls
+	}
+
+	result := *tls
+	result.Domains = make([]traefik.Domain, len(tls.Domains))
+	for i, domain := range tls.Domains {
+		result.Domains[i].Main = replaceArg(rewrite, "Host", true, mapDomain(rewrite.Domains, domain.Main))
+		for _, san := range domain.SANs {
+			result.Domains[i].SANs = append(result.Domains[i].SANs, replaceArg(rewrite, "Host", true, mapDomain(rewrite.Domains, san)))
+		}
+	}
+	return &result
+}
+
+// replaceArg applies the replacements of a server to an argument of a matcher
+func replaceArg(rewrite *config.Rewrite, matcher string, hostMatcher bool, arg string) string {
+	for _, replacement := range rewrite.Replacements {
+		if replacement.AppliesTo(matcher, hostMatcher) {
+			arg = replacement.Regexp().ReplaceAllString(arg, replacement.Replacement)
+		}
+	}
+	return arg
+}
+
+// mapDomain replaces the domain suffix of a host using the first matching mapping
+func mapDomain(domains []config.DomainMapping, host string) string {
+	for _, domain := range domains {
+		from, to := strings.Trim(domain.From, "."), strings.Trim(domain.To, ".")
+		if strings.EqualFold(host, from) {
+			return to
+		}
+		if len(host) > len(from) && strings.EqualFold(host[len(host)-len(from)-1:], "."+from) {
+			return host[:len(host)-len(from)] + to
+		}
+	}
+	return host
+}
+
+// mapDomainRegexp replaces the domain suffix of a HostRegexp pattern, either
+// escaped like ^.+\.lan$ or literal like {name:[a-z]+}.lan
+func mapDomainRegexp(domains []config.DomainMapping, pattern string) string {
+	for _, domain := range domains {
+		from, to := strings.Trim(domain.From, "."), strings.Trim(domain.To, ".")
+		forms := [][2]string{
+			{regexp.QuoteMeta("."+from) + "$", regexp.QuoteMeta("."+to) + "$"},
+			{regexp.QuoteMeta("." + from), regexp.QuoteMeta("." + to)},
+			{"." + from, "." + to},
+		}
+		for _, form := range forms {
+			if strings.HasSuffix(pattern, form[0]) {
+				return strings.TrimSuffix(pattern, form[0]) + form[1]
+			}
+		}
+	}
+	return pattern
+}
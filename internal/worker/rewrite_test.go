@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+func TestRewriteTLS(t *testing.T) {
+	fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{{
+		Name:        "whoami@docker",
+		EntryPoints: []string{"websecure"},
+		Rule:        "Host(`staging-whoami.lan`)",
+		TLS: &traefik.TLS{Domains: []traefik.Domain{
+			{Main: "staging-whoami.lan", SANs: []string{"www.whoami.lan", "whoami.internal"}},
+			{Main: "*.lan"},
+		}},
+	}}}
+
+	entries, _ := processFake(t, fake, `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      websecure: websecure
+    rewrite:
+      domains:
+        - from: lan
+          to: example.com
+      replacements:
+        - pattern: ^staging-
+          replacement: ""
+`)
+
+	// Certificates are requested for the published hosts
+	want := map[string]string{
+		"/domains/0/main":   "whoami.example.com",
+		"/domains/0/sans/0": "www.whoami.example.com",
+		"/domains/0/sans/1": "whoami.internal",
+		"/domains/1/main":   "*.example.com",
+	}
+	if got := keysBelow(entries, "traefik/http/routers/whoami_compute-1/tls"); !reflect.DeepEqual(got, want) {
+		t.Errorf("TLS entries = %v, want %v", got, want)
+	}
+	if got := entries["traefik/http/routers/whoami_compute-1/rule"]; got != "Host(`whoami.example.com`)" {
+		t.Errorf("rule = %s, want it rewritten like the domains", got)
+	}
+}
@@ -0,0 +1,324 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+)
+
+// ErrUnknownSplit is returned when a traffic split is not configured
+var ErrUnknownSplit = errors.New("unknown traffic split")
+
+// ErrSplitStore is returned when the weights of a traffic split could not be stored
+var ErrSplitStore = errors.New("error storing traffic split weights")
+
+// SplitStore keeps the weights of traffic splits changed at runtime, so that
+// they survive restarts and are applied by every replica becoming the leader
+type SplitStore interface {
+	// LoadSplitWeights returns the weights changed at runtime, by split name
+	LoadSplitWeights(ctx context.Context) (map[string]map[string]int, error)
+	// StoreSplitWeights records the weights of a split changed at runtime
+	StoreSplitWeights(ctx context.Context, split string, weights map[string]int) error
+	// DeleteSplitWeights forgets the weights of a split changed at runtime
+	DeleteSplitWeights(ctx context.Context, split string) error
+}
+
+// SplitRoute describes routers of several servers that were published as one
+// router of a traffic split
+type SplitRoute struct {
+	Split   string   `json:"split"`
+	Router  string   `json:"router"`
+	Service string   `json:"service"`
+	Servers []string `json:"servers"`
+}
+
+// SplitStatus describes a traffic split and the weights it currently applies
+type SplitStatus struct {
+	Name      string               `json:"name"`
+	Router    string               `json:"router"`
+	Servers   []config.SplitServer `json:"servers"`
+	Override  bool                 `json:"override"`
+	Persisted bool                 `json:"persisted"`
+	Published *SplitRoute          `json:"published,omitempty"`
+}
+
+// TrafficSplits returns every traffic split with the weights currently applied
+// and the router it was published as by the last run
+func (w *Worker) TrafficSplits() []SplitStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	splits := make([]SplitStatus, 0, len(w.config.TrafficSplits))
+	for i := range w.config.TrafficSplits {
+		split := &w.config.TrafficSplits[i]
+		weights, override := w.splitWeightsLocked(split)
+		status := SplitStatus{Name: split.Name, Router: split.Router, Override: override, Persisted: w.splitStore != nil}
+		for _, server := range split.Servers {
+			status.Servers = append(status.Servers, config.SplitServer{Name: server.Name, Weight: weights[server.Name]})
+		}
+		if w.lastResult != nil {
+			for j := range w.lastResult.Splits {
+				if w.lastResult.Splits[j].Split == split.Name {
+					route := w.lastResult.Splits[j]
+					status.Published = &route
+				}
+			}
+		}
+		splits = append(splits, status)
+	}
+	return splits
+}
+
+// SetSplitStore makes the weights of traffic splits changed at runtime
+// persistent. Without a store they are kept in memory until the relay restarts.
+func (w *Worker) SetSplitStore(store SplitStore) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.splitStore = store
+}
+
+// SplitWeightsPersisted reports whether weights changed at runtime survive
+// restarts and leader changes
+func (w *Worker) SplitWeightsPersisted() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.splitStore != nil
+}
+
+// SetSplitWeights overrides the weights of a traffic split and requests a run
+// to publish them. Servers left out keep their current weight.
+func (w *Worker) SetSplitWeights(ctx context.Context, name string, weights map[string]int) error {
+	split, ok := w.config.GetTrafficSplit(name)
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownSplit, name)
+	}
+
+	// Changes are serialized so the stored weights match the applied ones,
+	// without holding w.mu during the round trip to the store
+	w.splitMu.Lock()
+	defer w.splitMu.Unlock()
+
+	w.mu.RLock()
+	current, _ := w.splitWeightsLocked(split)
+	store := w.splitStore
+	w.mu.RUnlock()
+
+	for server, weight := range weights {
+		current[server] = weight
+	}
+	if err := split.Validate(current); err != nil {
+		return err
+	}
+	if store != nil {
+		if err := store.StoreSplitWeights(ctx, name, current); err != nil {
+			return fmt.Errorf("%w: %v", ErrSplitStore, err)
+		}
+	}
+
+	w.mu.Lock()
+	w.splitOverrides[name] = current
+	w.mu.Unlock()
+
+	log.Printf("Traffic split '%s' weights changed to %s", name, formatWeights(split, current))
+	w.RequestRun()
+	return nil
+}
+
+// ResetSplitWeights restores the configured weights of a traffic split and
+// requests a run to publish them
+func (w *Worker) ResetSplitWeights(ctx context.Context, name string) error {
+	if _, ok := w.config.GetTrafficSplit(name); !ok {
+		return fmt.Errorf("%w '%s'", ErrUnknownSplit, name)
+	}
+
+	w.splitMu.Lock()
+	defer w.splitMu.Unlock()
+
+	w.mu.RLock()
+	store := w.splitStore
+	w.mu.RUnlock()
+
+	if store != nil {
+		if err := store.DeleteSplitWeights(ctx, name); err != nil {
+			return fmt.Errorf("%w: %v", ErrSplitStore, err)
+		}
+	}
+
+	w.mu.Lock()
+	delete(w.splitOverrides, name)
+	w.mu.Unlock()
+
+	log.Printf("Traffic split '%s' weights reset to the configuration", name)
+	w.RequestRun()
+	return nil
+}
+
+// loadSplitWeights replaces the weights changed at runtime by those of the
+// split store. Weights no longer matching the configuration are ignored.
+func (w *Worker) loadSplitWeights(ctx context.Context) error {
+	w.mu.RLock()
+	store := w.splitStore
+	w.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	stored, err := store.LoadSplitWeights(ctx)
+	if err != nil {
+		return fmt.Errorf("error loading traffic split weights: %w", err)
+	}
+
+	overrides := make(map[string]map[string]int, len(stored))
+	for name, weights := range stored {
+		split, ok := w.config.GetTrafficSplit(name)
+		if !ok {
+			continue
+		}
+		if err := split.Validate(weights); err != nil {
+			log.Printf("Ignoring stored weights of traffic split '%s': %v", name, err)
+			continue
+		}
+		overrides[name] = weights
+		log.Printf("Traffic split '%s' weights restored to %s", name, formatWeights(split, weights))
+	}
+
+	w.mu.Lock()
+	w.splitOverrides = overrides
+	w.mu.Unlock()
+
+	return nil
+}
+
+// splitWeightsLocked returns a copy of the weights applied by a split and
+// whether they were changed at runtime. The caller must hold w.mu.
+func (w *Worker) splitWeightsLocked(split *config.TrafficSplit) (map[string]int, bool) {
+	override, ok := w.splitOverrides[split.Name]
+	if !ok {
+		return split.Weights(), false
+	}
+	weights := make(map[string]int, len(override))
+	for server, weight := range override {
+		weights[server] = weight
+	}
+	return weights, true
+}
+
+// mergeTrafficSplits replaces the HTTP routers of a split published by
+// several servers by the router of the first server listed in the split,
+// pointing at a weighted service over the services of all of them.
+// serverEntries holds the entries of every server and is updated in place.
+func (w *Worker) mergeTrafficSplits(serverEntries map[string]map[string]string) []SplitRoute {
+	w.mu.RLock()
+	weights := make(map[string]map[string]int, len(w.config.TrafficSplits))
+	for i := range w.config.TrafficSplits {
+		split := &w.config.TrafficSplits[i]
+		weights[split.Name], _ = w.splitWeightsLocked(split)
+	}
+	w.mu.RUnlock()
+
+	servers := make(map[string]config.Server, len(w.config.Servers))
+	for _, server := range w.config.Servers {
+		servers[server.Name] = server
+	}
+
+	var merged []SplitRoute
+	for _, split := range w.config.TrafficSplits {
+		var members []failoverMember
+		for _, splitServer := range split.Servers {
+			entries := serverEntries[splitServer.Name]
+			if entries == nil {
+				continue
+			}
+			router, ok := w.publishedRouterName(servers[splitServer.Name], split.Router, entries)
+			if !ok {
+				continue
+			}
+			members = append(members, failoverMember{server: splitServer.Name, router: router})
+		}
+		if len(members) < 2 {
+			continue
+		}
+
+		// The servers receiving traffic may not publish the router, a
+		// weighted service without any weight would send no traffic at all
+		present := make(map[string]int, len(members))
+		for _, member := range members {
+			present[member.server] = weights[split.Name][member.server]
+		}
+		if err := split.Validate(present); err != nil {
+			log.Printf("Not merging router '%s' of traffic split '%s': %v", split.Router, split.Name, err)
+			continue
+		}
+
+		// The entries of a server may be its retained state, never change those
+		for _, member := range members {
+			serverEntries[member.server] = copyEntries(serverEntries[member.server])
+		}
+
+		primary := members[0]
+		name := primary.router + "_weighted"
+		route := SplitRoute{Split: split.Name, Router: primary.router, Service: name}
+		for i, member := range members {
+			entries := serverEntries[member.server]
+			service := entries[getRedisKey("http", "routers", member.router, "service")]
+			serverEntries[primary.server][getRedisKey("http", "services", name, "weighted", "services", itoa(i), "name")] = service
+			serverEntries[primary.server][getRedisKey("http", "services", name, "weighted", "services", itoa(i), "weight")] = itoa(weights[split.Name][member.server])
+			if i > 0 {
+				deleteRouter(entries, "http", member.router)
+			}
+			route.Servers = append(route.Servers, member.server)
+		}
+		serverEntries[primary.server][getRedisKey("http", "routers", primary.router, "service")] = name
+
+		log.Printf("Split router '%s' between servers %s as '%s' with weights %s",
+			split.Router, strings.Join(route.Servers, ", "), primary.router, formatWeights(&split, weights[split.Name]))
+		merged = append(merged, route)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Split < merged[j].Split
+	})
+	return merged
+}
+
+// publishedRouterName returns the name the HTTP router of a server was
+// published under, trying every local entrypoint the name may depend on
+func (w *Worker) publishedRouterName(server config.Server, router string, entries map[string]string) (string, bool) {
+	names, err := newNamer(w.config, server)
+	if err != nil {
+		return "", false
+	}
+
+	localEPs := make([]string, 0, len(server.EntryPoints))
+	for _, localEP := range server.EntryPoints {
+		localEPs = append(localEPs, localEP)
+	}
+	sort.Strings(localEPs)
+
+	for _, localEP := range localEPs {
+		name, err := names.routerName(router, localEP, false)
+		if err != nil {
+			continue
+		}
+		if _, ok := entries[getRedisKey("http", "routers", name, "rule")]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// formatWeights lists the weights of a split in the order of its servers, e.g. "a=90, b=10"
+func formatWeights(split *config.TrafficSplit, weights map[string]int) string {
+	parts := make([]string, 0, len(split.Servers))
+	for _, server := range split.Servers {
+		parts = append(parts, fmt.Sprintf("%s=%d", server.Name, weights[server.Name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,312 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/naming"
+)
+
+// memorySplitStore is a SplitStore keeping weights in a map
+type memorySplitStore struct {
+	weights map[string]map[string]int
+	err     error
+}
+
+func (s *memorySplitStore) LoadSplitWeights(ctx context.Context) (map[string]map[string]int, error) {
+	return s.weights, s.err
+}
+
+func (s *memorySplitStore) StoreSplitWeights(ctx context.Context, split string, weights map[string]int) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.weights[split] = weights
+	return nil
+}
+
+func (s *memorySplitStore) DeleteSplitWeights(ctx context.Context, split string) error {
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.weights, split)
+	return nil
+}
+
+// splitConfig returns a configuration splitting the whoami router between
+// servers with the given weights, in order
+func splitConfig(weights ...int) *config.Config {
+	cfg := &config.Config{RouterNameTemplate: naming.DefaultRouterTemplate}
+	split := config.TrafficSplit{Name: "canary", Router: "whoami@docker"}
+	for i, weight := range weights {
+		name := string(rune('a' + i))
+		cfg.Servers = append(cfg.Servers, config.Server{Name: name, EntryPoints: map[string]string{"web": "web"}})
+		split.Servers = append(split.Servers, config.SplitServer{Name: name, Weight: weight})
+	}
+	cfg.TrafficSplits = []config.TrafficSplit{split}
+	return cfg
+}
+
+func TestMergeTrafficSplits(t *testing.T) {
+	rule := "Host(`whoami.example.com`)"
+
+	tests := []struct {
+		name      string
+		weights   []int
+		servers   map[string]map[string]string
+		override  map[string]int
+		want      []SplitRoute
+		wantKeys  map[string]string
+		unchanged []string
+	}{
+		{
+			name:    "two servers",
+			weights: []int{90, 10},
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami_a", rule, "a", "web"),
+				"b": httpRoute("whoami_b", rule, "b", "web"),
+			},
+			want: []SplitRoute{{Split: "canary", Router: "whoami_a", Service: "whoami_a_weighted", Servers: []string{"a", "b"}}},
+			wantKeys: map[string]string{
+				"traefik/http/routers/whoami_a/service":                              "whoami_a_weighted",
+				"traefik/http/services/whoami_a_weighted/weighted/services/0/name":   "a",
+				"traefik/http/services/whoami_a_weighted/weighted/services/0/weight": "90",
+				"traefik/http/services/whoami_a_weighted/weighted/services/1/name":   "b",
+				"traefik/http/services/whoami_a_weighted/weighted/services/1/weight": "10",
+				"traefik/http/routers/whoami_b/rule":                                 "",
+			},
+		},
+		{
+			name:     "weights changed at runtime",
+			weights:  []int{90, 10},
+			override: map[string]int{"a": 50},
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami_a", rule, "a", "web"),
+				"b": httpRoute("whoami_b", rule, "b", "web"),
+			},
+			want: []SplitRoute{{Split: "canary", Router: "whoami_a", Service: "whoami_a_weighted", Servers: []string{"a", "b"}}},
+			wantKeys: map[string]string{
+				"traefik/http/services/whoami_a_weighted/weighted/services/0/weight": "50",
+				"traefik/http/services/whoami_a_weighted/weighted/services/1/weight": "10",
+			},
+		},
+		{
+			name:    "router on a single server",
+			weights: []int{90, 10},
+			servers: map[string]map[string]string{
+				"a": httpRoute("whoami_a", rule, "a", "web"),
+				"b": httpRoute("other_b", rule, "b", "web"),
+			},
+			unchanged: []string{"a", "b"},
+		},
+		{
+			name:    "server down",
+			weights: []int{90, 10, 0},
+			servers: map[string]map[string]string{
+				"a": nil,
+				"b": httpRoute("whoami_b", rule, "b", "web"),
+				"c": httpRoute("whoami_c", rule, "c", "web"),
+			},
+			want: []SplitRoute{{Split: "canary", Router: "whoami_b", Service: "whoami_b_weighted", Servers: []string{"b", "c"}}},
+			wantKeys: map[string]string{
+				"traefik/http/services/whoami_b_weighted/weighted/services/0/weight": "10",
+				"traefik/http/services/whoami_b_weighted/weighted/services/1/weight": "0",
+			},
+		},
+		{
+			name:    "no weight on the servers publishing the router",
+			weights: []int{100, 0, 0},
+			servers: map[string]map[string]string{
+				"a": nil,
+				"b": httpRoute("whoami_b", rule, "b", "web"),
+				"c": httpRoute("whoami_c", rule, "c", "web"),
+			},
+			unchanged: []string{"b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := New(splitConfig(tt.weights...), nil)
+			if tt.override != nil {
+				if err := w.SetSplitWeights(context.Background(), "canary", tt.override); err != nil {
+					t.Fatalf("SetSplitWeights() error = %v", err)
+				}
+			}
+
+			originals := make(map[string]map[string]string)
+			for server, entries := range tt.servers {
+				originals[server] = copyEntries(entries)
+			}
+
+			got := w.mergeTrafficSplits(tt.servers)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeTrafficSplits() = %+v, want %+v", got, tt.want)
+			}
+
+			merged := mergeEntries(tt.servers["a"], tt.servers["b"], tt.servers["c"])
+			for key, want := range tt.wantKeys {
+				if merged[key] != want {
+					t.Errorf("%s = %q, want %q", key, merged[key], want)
+				}
+			}
+			for _, server := range tt.unchanged {
+				if !reflect.DeepEqual(tt.servers[server], originals[server]) {
+					t.Errorf("entries of server '%s' were changed", server)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitWeights(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		weights map[string]int
+		wantErr bool
+		want    map[string]int
+	}{
+		{name: "partial change", weights: map[string]int{"b": 50}, want: map[string]int{"a": 90, "b": 50}},
+		{name: "unknown server", weights: map[string]int{"x": 50}, wantErr: true, want: map[string]int{"a": 90, "b": 10}},
+		{name: "negative weight", weights: map[string]int{"a": -1}, wantErr: true, want: map[string]int{"a": 90, "b": 10}},
+		{name: "no traffic left", weights: map[string]int{"a": 0, "b": 0}, wantErr: true, want: map[string]int{"a": 90, "b": 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memorySplitStore{weights: make(map[string]map[string]int)}
+			w := New(splitConfig(90, 10), nil)
+			w.SetSplitStore(store)
+
+			err := w.SetSplitWeights(ctx, "canary", tt.weights)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSplitWeights() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			status := w.TrafficSplits()[0]
+			got := make(map[string]int)
+			for _, server := range status.Servers {
+				got[server.Name] = server.Weight
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("weights = %v, want %v", got, tt.want)
+			}
+			if status.Override == tt.wantErr || !status.Persisted {
+				t.Errorf("Override = %v, Persisted = %v", status.Override, status.Persisted)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(store.weights["canary"], tt.want) {
+				t.Errorf("stored weights = %v, want %v", store.weights["canary"], tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown split", func(t *testing.T) {
+		w := New(splitConfig(90, 10), nil)
+		if err := w.SetSplitWeights(ctx, "other", map[string]int{"a": 1}); !errors.Is(err, ErrUnknownSplit) {
+			t.Errorf("SetSplitWeights() error = %v, want ErrUnknownSplit", err)
+		}
+		if err := w.ResetSplitWeights(ctx, "other"); !errors.Is(err, ErrUnknownSplit) {
+			t.Errorf("ResetSplitWeights() error = %v, want ErrUnknownSplit", err)
+		}
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		w := New(splitConfig(90, 10), nil)
+		w.SetSplitStore(&memorySplitStore{err: errors.New("connection refused")})
+		if err := w.SetSplitWeights(ctx, "canary", map[string]int{"a": 1}); !errors.Is(err, ErrSplitStore) {
+			t.Errorf("SetSplitWeights() error = %v, want ErrSplitStore", err)
+		}
+		if w.TrafficSplits()[0].Override {
+			t.Error("weights were changed although they could not be stored")
+		}
+	})
+}
+
+func TestLoadSplitWeights(t *testing.T) {
+	ctx := context.Background()
+	store := &memorySplitStore{weights: map[string]map[string]int{
+		"canary":  {"a": 20, "b": 80},
+		"removed": {"a": 1},
+	}}
+
+	// A replica taking over applies the weights stored by the previous leader
+	w := New(splitConfig(90, 10), nil)
+	w.SetSplitStore(store)
+	if err := w.loadSplitWeights(ctx); err != nil {
+		t.Fatalf("loadSplitWeights() error = %v", err)
+	}
+	status := w.TrafficSplits()[0]
+	if !status.Override || status.Servers[0].Weight != 20 || status.Servers[1].Weight != 80 {
+		t.Errorf("TrafficSplits() = %+v, want the stored weights", status)
+	}
+
+	// Stored weights no longer matching the configuration are ignored
+	store.weights["canary"] = map[string]int{"a": 20, "x": 80}
+	if err := w.loadSplitWeights(ctx); err != nil {
+		t.Fatalf("loadSplitWeights() error = %v", err)
+	}
+	if w.TrafficSplits()[0].Override {
+		t.Error("invalid stored weights were applied")
+	}
+
+	// Resetting forgets the stored weights
+	store.weights["canary"] = map[string]int{"a": 20, "b": 80}
+	if err := w.loadSplitWeights(ctx); err != nil {
+		t.Fatalf("loadSplitWeights() error = %v", err)
+	}
+	if err := w.ResetSplitWeights(ctx, "canary"); err != nil {
+		t.Fatalf("ResetSplitWeights() error = %v", err)
+	}
+	if _, ok := store.weights["canary"]; ok || w.TrafficSplits()[0].Override {
+		t.Error("weights were not reset")
+	}
+}
+
+// lockCheckingStore is a memorySplitStore recording whether it was called
+// while the worker held its lock
+type lockCheckingStore struct {
+	memorySplitStore
+	w      *Worker
+	locked bool
+}
+
+func (s *lockCheckingStore) check() {
+	if !s.w.mu.TryLock() {
+		s.locked = true
+		return
+	}
+	s.w.mu.Unlock()
+}
+
+func (s *lockCheckingStore) StoreSplitWeights(ctx context.Context, split string, weights map[string]int) error {
+	s.check()
+	return s.memorySplitStore.StoreSplitWeights(ctx, split, weights)
+}
+
+func (s *lockCheckingStore) DeleteSplitWeights(ctx context.Context, split string) error {
+	s.check()
+	return s.memorySplitStore.DeleteSplitWeights(ctx, split)
+}
+
+func TestSplitStoreUnlocked(t *testing.T) {
+	ctx := context.Background()
+	w := New(splitConfig(90, 10), nil)
+	store := &lockCheckingStore{memorySplitStore: memorySplitStore{weights: make(map[string]map[string]int)}, w: w}
+	w.SetSplitStore(store)
+
+	// API requests keep being served while the store is slow
+	if err := w.SetSplitWeights(ctx, "canary", map[string]int{"b": 50}); err != nil {
+		t.Fatalf("SetSplitWeights() error = %v", err)
+	}
+	if err := w.ResetSplitWeights(ctx, "canary"); err != nil {
+		t.Fatalf("ResetSplitWeights() error = %v", err)
+	}
+	if store.locked {
+		t.Error("split store called while holding the worker lock")
+	}
+}
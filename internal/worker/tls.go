@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+// routerTLS returns the TLS settings to publish for a router on the given
+// global entrypoints, or nil when the router is published without TLS
+func routerTLS(settings config.TLS, tls *traefik.TLS, entryPoints []string) *traefik.TLS {
+	var result *traefik.TLS
+	if tls != nil && settings.Mode != config.TLSDisabled {
+		copied := *tls
+		result = &copied
+	}
+
+	// Force TLS on the configured entrypoints
+	if result == nil && containsAny(settings.EntryPoints, entryPoints) {
+		result = &traefik.TLS{}
+	}
+	if result == nil {
+		return nil
+	}
+
+	// The local certResolver usually does not exist on the main instance
+	if result.Passthrough {
+		return result
+	}
+	if settings.Mode == config.TLSOverride || (result.CertResolver == "" && settings.CertResolver != "") {
+		result.CertResolver = settings.CertResolver
+	}
+
+	return result
+}
+
+// tcpRouterTLS returns the TLS settings to publish for a TCP router. With
+// passthrough forced, the main instance leaves TLS to the local instance.
+func tcpRouterTLS(settings config.TLS, tls *traefik.TLS, entryPoints []string) *traefik.TLS {
+	result := routerTLS(settings, tls, entryPoints)
+	if result != nil && settings.Passthrough {
+		return &traefik.TLS{Passthrough: true}
+	}
+	return result
+}
+
+// putTLS writes the TLS settings of a router
+func putTLS(entries map[string]string, protocol, routerName string, tls *traefik.TLS) {
+	if tls == nil {
+		return
+	}
+
+	prefix := []string{protocol, "routers", routerName, "tls"}
+	key := func(segments ...string) string {
+		return getRedisKey(append(append([]string{}, prefix...), segments...)...)
+	}
+
+	var written bool
+	if tls.Passthrough {
+		entries[key("passthrough")] = "true"
+		written = true
+	}
+	if tls.CertResolver != "" {
+		entries[key("certresolver")] = tls.CertResolver
+		written = true
+	}
+	if tls.Options != "" {
+		entries[key("options")] = tls.Options
+		written = true
+	}
+	for i, domain := range tls.Domains {
+		entries[key("domains", itoa(i), "main")] = domain.Main
+		for j, san := range domain.SANs {
+			entries[key("domains", itoa(i), "sans", itoa(j))] = san
+		}
+		written = true
+	}
+
+	// Enable TLS with the defaults when there is nothing else to set
+	if !written {
+		entries[key()] = "true"
+	}
+}
+
+// containsAny reports whether any of values is in list
+func containsAny(list, values []string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+// keysBelow returns the entries whose key starts with prefix, by the rest of the key
+func keysBelow(entries map[string]string, prefix string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range entries {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			result[rest] = value
+		}
+	}
+	return result
+}
+
+func TestHttpRouterTLS(t *testing.T) {
+	routerTLS := &traefik.TLS{
+		CertResolver: "local",
+		Domains:      []traefik.Domain{{Main: "whoami.lan", SANs: []string{"www.whoami.lan"}}},
+		Options:      "modern@file",
+	}
+
+	tests := []struct {
+		name   string
+		tls    string
+		router *traefik.TLS
+		want   map[string]string
+	}{
+		{
+			name:   "forwarded",
+			router: routerTLS,
+			want: map[string]string{
+				"/certresolver":     "local",
+				"/domains/0/main":   "whoami.lan",
+				"/domains/0/sans/0": "www.whoami.lan",
+				"/options":          "modern@file",
+			},
+		},
+		{
+			name:   "certResolver overridden",
+			tls:    "{mode: override, certResolver: main}",
+			router: routerTLS,
+			want: map[string]string{
+				"/certresolver":     "main",
+				"/domains/0/main":   "whoami.lan",
+				"/domains/0/sans/0": "www.whoami.lan",
+				"/options":          "modern@file",
+			},
+		},
+		{
+			name:   "certResolver only set when missing",
+			tls:    "{certResolver: main}",
+			router: &traefik.TLS{Options: "modern@file"},
+			want:   map[string]string{"/certresolver": "main", "/options": "modern@file"},
+		},
+		{
+			name:   "disabled",
+			tls:    "{mode: disabled}",
+			router: routerTLS,
+			want:   map[string]string{},
+		},
+		{
+			name: "forced on an entrypoint",
+			tls:  "{entryPoints: [websecure]}",
+			want: map[string]string{"": "true"},
+		},
+		{
+			name: "without TLS",
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{{
+				Name:        "whoami@docker",
+				EntryPoints: []string{"websecure"},
+				Rule:        "Host(`whoami.lan`)",
+				TLS:         tt.router,
+			}}}
+			content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      websecure: websecure
+`
+			if tt.tls != "" {
+				content += "    tls: " + tt.tls + "\n"
+			}
+
+			entries, _ := processFake(t, fake, content)
+			got := keysBelow(entries, "traefik/http/routers/whoami_compute-1/tls")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TLS entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTcpRouterTLS(t *testing.T) {
+	tests := []struct {
+		name   string
+		tls    string
+		router *traefik.TLS
+		want   map[string]string
+	}{
+		{
+			name:   "passthrough forwarded",
+			router: &traefik.TLS{Passthrough: true},
+			want:   map[string]string{"/passthrough": "true"},
+		},
+		{
+			name:   "passthrough forced",
+			tls:    "{passthrough: true, certResolver: main}",
+			router: &traefik.TLS{CertResolver: "local", Domains: []traefik.Domain{{Main: "db.lan"}}},
+			want:   map[string]string{"/passthrough": "true"},
+		},
+		{
+			name:   "terminated on the main instance",
+			tls:    "{mode: override, certResolver: main}",
+			router: &traefik.TLS{CertResolver: "local"},
+			want:   map[string]string{"/certresolver": "main"},
+		},
+		{
+			name: "without TLS",
+			tls:  "{passthrough: true}",
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTraefik{
+				tcpRouters: []traefik.TcpRouter{{
+					Name:        "db@docker",
+					EntryPoints: []string{"postgres"},
+					Rule:        "HostSNI(`db.lan`)",
+					TLS:         tt.router,
+				}},
+				entryPoints: []traefik.EntryPoint{{Name: "postgres", Address: ":5432"}},
+			}
+			content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      postgres: postgres
+`
+			if tt.tls != "" {
+				content += "    tls: " + tt.tls + "\n"
+			}
+
+			entries, _ := processFake(t, fake, content)
+			got := keysBelow(entries, "traefik/tcp/routers/db_compute-1/tls")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TLS entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
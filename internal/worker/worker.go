@@ -4,96 +4,391 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hhftechnology/traefik-relay/internal/config"
-	"github.com/hhftechnology/traefik-relay/internal/redis"
 	"github.com/hhftechnology/traefik-relay/internal/traefik"
 )
 
+// Publisher writes the computed entries to a configuration backend
+type Publisher interface {
+	// Name returns the name of the backend, used in logs
+	Name() string
+	// Load returns the entries previously published by the relay
+	Load(ctx context.Context) (map[string]string, error)
+	// Publish applies a run: entries is the complete set of entries, updated
+	// holds the added or changed ones and removed the keys no longer relayed
+	Publish(ctx context.Context, entries, updated map[string]string, removed []string) error
+	// Close releases the resources held by the publisher
+	Close() error
+}
+
+// SnapshotPublisher is implemented by publishers that render the complete set
+// of entries on each publish, comparing it with what they wrote last
+type SnapshotPublisher interface {
+	// PublishesSnapshots reports that Publish must be called on every run,
+	// even when the entries did not change since the state loaded by Load
+	PublishesSnapshots() bool
+}
+
 // Worker handles the synchronization between Traefik instances
 type Worker struct {
 	config      *config.Config
-	redisClient *redis.Client
+	publishers  []Publisher
+	published   []map[string]string
+	servers     map[string]*ServerState
 	oldEntries  map[string]string
+	served      map[string]string
+	lastResult  *RunResult
+	pending     *PendingChange
+	runRequests chan struct{}
+	loaded      bool
+	lastRun     time.Time
+	hasRun      bool
+	mu          sync.RWMutex
+
+	// Weights of traffic splits changed through the API, kept in splitStore
+	// when set. splitMu serializes the changes.
+	splitOverrides map[string]map[string]int
+	splitStore     SplitStore
+	splitMu        sync.Mutex
+
+	// Last known good entries of the servers, kept in retainStore when set.
+	// storedRetained holds those stored and is only used by runs.
+	retainStore    RetainStore
+	storedRetained map[string]map[string]string
 }
 
-// New creates a new worker
-func New(cfg *config.Config, redisClient *redis.Client) *Worker {
+// New creates a new worker. publishers may be empty when entries are only
+// served through the HTTP provider endpoint.
+func New(cfg *config.Config, publishers []Publisher) *Worker {
 	return &Worker{
 		config:      cfg,
-		redisClient: redisClient,
+		publishers:  publishers,
+		published:   make([]map[string]string, len(publishers)),
+		servers:     make(map[string]*ServerState),
+		runRequests: make(chan struct{}, 1),
 		oldEntries:  make(map[string]string),
+
+		splitOverrides: make(map[string]map[string]int),
+		storedRetained: make(map[string]map[string]string),
 	}
 }
 
-// Execute fetches configurations from all Traefik instances and updates Redis
-func (w *Worker) Execute(ctx context.Context) error {
+// Execute fetches configurations from all Traefik instances, publishes the
+// changes and returns a summary of the run
+func (w *Worker) Execute(ctx context.Context) (*RunResult, error) {
+	return w.run(ctx, true)
+}
+
+// Build fetches configurations from all Traefik instances and serves the
+// entries through the HTTP provider endpoint without publishing them. Replicas
+// run it while they are followers, so that every replica answers the endpoint.
+func (w *Worker) Build(ctx context.Context) (*RunResult, error) {
+	return w.run(ctx, false)
+}
+
+// run performs a run, publishing its entries when publish is set, and returns
+// a summary of it
+func (w *Worker) run(ctx context.Context, publish bool) (*RunResult, error) {
 	log.Printf("Worker running at: %s", ctx.Value("time"))
 
-	// Store current entries to keep track of what should be removed later
-	entries := make(map[string]string)
+	result := &RunResult{Started: time.Now()}
+	err := w.execute(ctx, result, publish)
+	result.Duration = time.Since(result.Started).Round(time.Millisecond).String()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	w.mu.Lock()
+	w.lastResult = result
+	w.mu.Unlock()
+
+	return result, err
+}
+
+// execute performs a run and fills in result. Unless publish is set, the
+// entries are only served and the backends are left untouched.
+func (w *Worker) execute(ctx context.Context, result *RunResult, publish bool) error {
+	// On the first run, rebuild the published state from the backends so keys
+	// left behind by a previous process are cleaned up and servers failing
+	// from the start keep their last known good entries
+	if publish && !w.isLoaded() {
+		if err := w.loadPublished(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Followers pick up the state kept by the leader on every run
+	if !publish {
+		if err := w.loadRetained(ctx); err != nil {
+			return err
+		}
+		if err := w.loadSplitWeights(ctx); err != nil {
+			return err
+		}
+	}
 
 	// Process each server
+	perServer := make(map[string]map[string]string)
 	for _, server := range w.config.Servers {
-		if err := w.processServer(ctx, server, entries); err != nil {
+		serverEntries, report, err := w.processServer(ctx, server)
+		if err != nil {
 			log.Printf("Error processing server '%s': %v", server.Name, err)
 			// Continue with other servers even if one fails
-			continue
 		}
+		if report != nil {
+			result.Collisions = append(result.Collisions, report.collisions...)
+			result.Skipped = append(result.Skipped, report.skipped...)
+		}
+
+		// A failing server may still contribute its last known good entries
+		perServer[server.Name] = w.applyRetainPolicy(server, serverEntries, err)
 	}
 
-	// Find keys to remove (those that were in oldEntries but not in new entries)
-	var keysToRemove []string
-	for key := range w.oldEntries {
-		if _, exists := entries[key]; !exists {
-			keysToRemove = append(keysToRemove, key)
+	// Publish the routes shared by several servers once
+	result.Splits = w.mergeTrafficSplits(perServer)
+	result.Failovers = mergeFailoverGroups(w.config.Failover, perServer)
+
+	// Store current entries to keep track of what should be removed later
+	entries := make(map[string]string)
+	for _, server := range w.config.Servers {
+		for key, value := range perServer[server.Name] {
+			entries[key] = value
 		}
 	}
 
-	// Remove old keys from Redis
-	if len(keysToRemove) > 0 {
-		if err := w.redisClient.DeleteKeys(ctx, keysToRemove); err != nil {
-			log.Printf("Error deleting old keys: %v", err)
-		}
+	// Never let one server's routers or services replace another's
+	result.Collisions = append(result.Collisions, dropSharedObjects(perServer, entries)...)
+
+	// Leave routers and services published by other relay instances alone
+	conflicts, err := w.resolveConflicts(ctx, entries)
+	if err != nil {
+		return err
 	}
+	result.Conflicts = conflicts
+	result.Entries = len(entries)
 
-	// Update Redis with new entries
-	if err := w.redisClient.StoreEntries(ctx, entries); err != nil {
-		log.Printf("Error storing entries in Redis: %v", err)
+	// Report the changes compared to the previous run
+	changes := computeDiff(w.oldEntries, entries)
+	result.Added = changes.added
+	result.Changed = changes.changed
+	result.Removed = changes.removed
+
+	// Refuse to apply runs that would remove too much at once
+	if err := w.checkDeletionGuard(w.oldEntries, entries, changes.removed); err != nil {
 		return err
 	}
 
+	// Serve the new configuration through the HTTP provider, whatever the
+	// outcome of publishing to the backends
+	w.mu.Lock()
+	w.served = entries
+	w.lastRun = time.Now()
+	w.hasRun = true
+	w.mu.Unlock()
+
+	// Followers leave publishing to the leader
+	if publish {
+		if err := w.publish(ctx, entries); err != nil {
+			return err
+		}
+	}
+
 	// Update oldEntries for the next run
+	w.mu.Lock()
 	w.oldEntries = entries
+	w.mu.Unlock()
 
-	return nil
+	if !publish {
+		return nil
+	}
+
+	// Record the last known good entries of every server for the next process
+	return w.storeRetained(ctx)
+}
+
+// publish applies the entries of a run to every backend
+func (w *Worker) publish(ctx context.Context, entries map[string]string) error {
+	// Publish to every backend only what changed for that backend. A failed
+	// backend keeps its previous state so the changes are retried next run.
+	var publishErr error
+	for i, publisher := range w.publishers {
+		d := computeDiff(w.published[i], entries)
+		if d.empty() && !publishesSnapshots(publisher) {
+			continue
+		}
+
+		if err := publisher.Publish(ctx, entries, d.updated(entries), d.removed); err != nil {
+			log.Printf("Error publishing entries to %s: %v", publisher.Name(), err)
+			if publishErr == nil {
+				publishErr = fmt.Errorf("%s: %w", publisher.Name(), err)
+			}
+			continue
+		}
+		w.published[i] = copyEntries(entries)
+	}
+	return publishErr
+}
+
+// Reset makes the next run rebuild the published state from the backends.
+// It is used when a replica takes over from another leader or when keys were
+// deleted from a backend.
+func (w *Worker) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.loaded = false
+}
+
+// RequestRun asks for a run outside of the regular schedule
+func (w *Worker) RequestRun() {
+	select {
+	case w.runRequests <- struct{}{}:
+	default:
+	}
+}
+
+// RunRequests is signaled when a run is requested outside of the regular schedule
+func (w *Worker) RunRequests() <-chan struct{} {
+	return w.runRequests
+}
+
+// LastResult returns the result of the last run, or nil before the first run
+func (w *Worker) LastResult() *RunResult {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.lastResult
+}
+
+// Conflicts returns the routers and services skipped by the last run because
+// another relay instance publishes them
+func (w *Worker) Conflicts() []Conflict {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.lastResult == nil {
+		return nil
+	}
+	return append([]Conflict(nil), w.lastResult.Conflicts...)
+}
+
+// Skipped returns the routers of a server that were not relayed in the last run
+func (w *Worker) Skipped(server string) []SkippedRouter {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	skipped := []SkippedRouter{}
+	if w.lastResult == nil {
+		return skipped
+	}
+	for _, router := range w.lastResult.Skipped {
+		if router.Server == server {
+			skipped = append(skipped, router)
+		}
+	}
+	return skipped
+}
+
+// publishesSnapshots reports whether publisher is called on every run
+func publishesSnapshots(publisher Publisher) bool {
+	snapshots, ok := publisher.(SnapshotPublisher)
+	return ok && snapshots.PublishesSnapshots()
+}
+
+// isLoaded reports whether the published state has been loaded from the backends
+func (w *Worker) isLoaded() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.loaded
 }
 
-// processServer processes a single server and adds its entries to the provided map
-func (w *Worker) processServer(ctx context.Context, server config.Server, entries map[string]string) error {
+// loadPublished records the entries each backend reports as owned, seeds
+// oldEntries with them and restores the last known good entries of the servers
+func (w *Worker) loadPublished(ctx context.Context) error {
+	published := make([]map[string]string, len(w.publishers))
+	all := make(map[string]string)
+	for i, publisher := range w.publishers {
+		entries, err := publisher.Load(ctx)
+		if err != nil {
+			return fmt.Errorf("error loading published entries from %s: %w", publisher.Name(), err)
+		}
+		published[i] = entries
+		for key, value := range entries {
+			all[key] = value
+		}
+	}
+
+	log.Printf("Loaded %d previously published entries", len(all))
+
+	w.mu.Lock()
+	w.published = published
+	w.oldEntries = all
+	w.loaded = true
+	w.mu.Unlock()
+
+	if err := w.loadRetained(ctx); err != nil {
+		return err
+	}
+
+	// A new leader applies the weights changed through the previous one
+	return w.loadSplitWeights(ctx)
+}
+
+// Entries returns a copy of the entries built by the last run that was not
+// held back, and the time of that run. Unlike oldEntries, they never hold the
+// state loaded from the backends. ok is false until the first run has built them.
+func (w *Worker) Entries() (entries map[string]string, updated time.Time, ok bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return copyEntries(w.served), w.lastRun, w.hasRun
+}
+
+// processServer processes a single server and returns its entries, without
+// the routers whose name was generated more than once, and a report of the
+// routers that were not relayed. Any error means the entries are incomplete
+// and must not be published.
+func (w *Worker) processServer(ctx context.Context, server config.Server) (map[string]string, *serverReport, error) {
 	// Create a Traefik client for this server
 	client := traefik.NewClient(&server)
+	entries := make(map[string]string)
+	report := &serverReport{server: server.Name, sources: make(routerSources)}
 
-	// Set up the destination service in Redis
-	entries[getRedisKey("http", "services", server.Name, "loadbalancer", "servers", "0", "url")] = server.DestinationAddress
-	entries[getRedisKey("tcp", "services", server.Name, "loadbalancer", "servers", "0", "url")] = server.DestinationAddress
+	names, err := newNamer(w.config, server)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Process HTTP routers
-	if err := w.processHttpRouters(ctx, client, server, entries); err != nil {
-		log.Printf("Error processing HTTP routers for server '%s': %v", server.Name, err)
+	if err := w.processHttpRouters(ctx, client, server, names, entries, report); err != nil {
+		return nil, nil, fmt.Errorf("error processing HTTP routers: %w", err)
 	}
 
 	// Process TCP routers
-	if err := w.processTcpRouters(ctx, client, server, entries); err != nil {
-		log.Printf("Error processing TCP routers for server '%s': %v", server.Name, err)
+	if err := w.processTcpRouters(ctx, client, server, names, entries, report); err != nil {
+		return nil, nil, fmt.Errorf("error processing TCP routers: %w", err)
 	}
 
-	return nil
+	// Process UDP routers
+	if err := w.processUdpRouters(ctx, client, server, names, entries, report); err != nil {
+		return nil, nil, fmt.Errorf("error processing UDP routers: %w", err)
+	}
+
+	report.collisions = report.sources.dropCollisions(server.Name, entries)
+	return entries, report, nil
 }
 
 // processHttpRouters processes HTTP routers for a server
-func (w *Worker) processHttpRouters(ctx context.Context, client *traefik.Client, server config.Server, entries map[string]string) error {
+func (w *Worker) processHttpRouters(ctx context.Context, client *traefik.Client, server config.Server, names *namer, entries map[string]string, report *serverReport) error {
 	// Fetch HTTP routers
 	routers, err := client.GetHttpRouters(ctx)
 	if err != nil {
@@ -112,52 +407,68 @@ func (w *Worker) processHttpRouters(ctx context.Context, client *traefik.Client,
 	var serviceNames []string
 
 	if server.GetServerForwardMiddlewares(w.config.ForwardMiddlewares) {
+		// Without the list, local middlewares would be forwarded as well
 		middlewares, err := client.GetMiddlewares(ctx)
 		if err != nil {
-			log.Printf("Error fetching middlewares for server '%s': %v", server.Name, err)
-		} else {
-			for _, m := range middlewares {
-				middlewareNames = append(middlewareNames, m.Name)
-			}
+			return fmt.Errorf("error fetching middlewares: %w", err)
+		}
+		for _, m := range middlewares {
+			middlewareNames = append(middlewareNames, m.Name)
 		}
 	}
 
 	if server.GetServerForwardServices(w.config.ForwardServices) {
+		// Without the list, routers would point at local services on the main instance
 		services, err := client.GetServices(ctx)
 		if err != nil {
-			log.Printf("Error fetching services for server '%s': %v", server.Name, err)
-		} else {
-			for _, s := range services {
-				serviceNames = append(serviceNames, s.Name)
-			}
+			return fmt.Errorf("error fetching services: %w", err)
+		}
+		for _, s := range services {
+			serviceNames = append(serviceNames, s.Name)
 		}
 	}
 
 	// Process each router
 	for _, router := range routers {
-		routerName := router.Name
-		
-		// Handle routers with provider (e.g. "router@provider")
-		if strings.Contains(routerName, "@") {
-			parts := strings.SplitN(routerName, "@", 2)
-			routerName = parts[0] + "_" + server.Name
+		target := filterTarget(router.Name, router.Provider, router.Status, router.Rule, router.EntryPoints, router.Middlewares)
+		if reason := w.skipReason(server, target, router.Error); reason != "" {
+			report.skip("http", router.Name, reason)
+			continue
+		}
+		publishedRule, err := rewriteRule(server.Rewrite, router.Rule)
+		if err != nil {
+			report.skip("http", router.Name, fmt.Sprintf("rule cannot be rewritten: %v", err))
+			continue
 		}
 
 		// Check if this router uses any of our entrypoints
-		var registeredEntryPoints int
-		for globalEP, localEP := range server.EntryPoints {
-			for _, routerEP := range router.EntryPoints {
-				if routerEP == localEP {
-					entries[getRedisKey("http", "routers", routerName, "entrypoints", itoa(registeredEntryPoints))] = globalEP
-					registeredEntryPoints++
-				}
-			}
+		localEPs, groups := groupEntryPoints(server, router.EntryPoints)
+		var registeredEntryPoints []string
+		for _, localEP := range localEPs {
+			registeredEntryPoints = append(registeredEntryPoints, groups[localEP]...)
 		}
 
 		// Only continue if this router is using our entrypoints
-		if registeredEntryPoints > 0 {
-			entries[getRedisKey("http", "routers", routerName, "rule")] = router.Rule
-			entries[getRedisKey("http", "routers", routerName, "service")] = server.Name
+		if len(registeredEntryPoints) > 0 {
+			routerName, err := names.routerName(router.Name, localEPs[0], false)
+			if err != nil {
+				return fmt.Errorf("error naming router '%s': %w", router.Name, err)
+			}
+			serviceName, err := names.serviceName(router.Name, localEPs[0], false)
+			if err != nil {
+				return fmt.Errorf("error naming service of router '%s': %w", router.Name, err)
+			}
+
+			report.sources.add("http", routerName, router.Name)
+			for i, globalEP := range registeredEntryPoints {
+				entries[getRedisKey("http", "routers", routerName, "entrypoints", itoa(i))] = globalEP
+			}
+			entries[getRedisKey("http", "routers", routerName, "rule")] = publishedRule
+			if priority, ok := server.GetServerPriority(router.Priority, publishedRule); ok {
+				entries[getRedisKey("http", "routers", routerName, "priority")] = strconv.FormatInt(priority, 10)
+			}
+			entries[getRedisKey("http", "routers", routerName, "service")] = serviceName
+			putTLS(entries, "http", routerName, rewriteTLS(server.Rewrite, routerTLS(server.GetServerTLS(), router.TLS, registeredEntryPoints)))
 
 			// Handle forwarding of services
 			if server.GetServerForwardServices(w.config.ForwardServices) {
@@ -175,10 +486,24 @@ func (w *Worker) processHttpRouters(ctx context.Context, client *traefik.Client,
 				}
 			}
 
+			// Set up the destination service unless the router uses a forwarded one
+			if entries[getRedisKey("http", "routers", routerName, "service")] == serviceName {
+				for i, destination := range server.GetDestinations() {
+					entries[getRedisKey("http", "services", serviceName, "loadbalancer", "servers", itoa(i), "url")] = destination.Address
+					if destination.Weight > 0 {
+						entries[getRedisKey("http", "services", serviceName, "loadbalancer", "servers", itoa(i), "weight")] = itoa(destination.Weight)
+					}
+				}
+				putHTTPHealthCheck(entries, serviceName, server.HealthCheck)
+				putLoadBalancer(entries, serviceName, server.LoadBalancer)
+			}
+
 			// Handle forwarding of middlewares
 			if server.GetServerForwardMiddlewares(w.config.ForwardMiddlewares) && len(router.Middlewares) > 0 {
+				optIn := server.GetServerOptIn(w.config.OptIn)
 				for i, middleware := range router.Middlewares {
-					shouldForward := true
+					// The opt-in marker only matters to the relay
+					shouldForward := !optIn.IsMarker(middleware)
 					
 					for _, mwName := range middlewareNames {
 						if strings.EqualFold(mwName, middleware) {
@@ -199,7 +524,7 @@ func (w *Worker) processHttpRouters(ctx context.Context, client *traefik.Client,
 }
 
 // processTcpRouters processes TCP routers for a server
-func (w *Worker) processTcpRouters(ctx context.Context, client *traefik.Client, server config.Server, entries map[string]string) error {
+func (w *Worker) processTcpRouters(ctx context.Context, client *traefik.Client, server config.Server, names *namer, entries map[string]string, report *serverReport) error {
 	// Fetch TCP routers
 	routers, err := client.GetTcpRouters(ctx)
 	if err != nil {
@@ -213,37 +538,221 @@ func (w *Worker) processTcpRouters(ctx context.Context, client *traefik.Client,
 		return nil
 	}
 
+	// TCP services have no URLs, they need the port the server listens on
+	hosts, ports, err := destinationPorts(ctx, client, server)
+	if err != nil {
+		return err
+	}
+
 	// Process each router
 	for _, router := range routers {
-		serviceName := router.Service
-		
-		// Handle services with provider (e.g. "service@provider")
-		if strings.Contains(serviceName, "@") {
-			parts := strings.SplitN(serviceName, "@", 2)
-			serviceName = parts[0] + "_" + server.Name
+		target := filterTarget(router.Name, router.Provider, router.Status, router.Rule, router.EntryPoints, nil)
+		if reason := w.skipReason(server, target, router.Error); reason != "" {
+			report.skip("tcp", router.Name, reason)
+			continue
+		}
+		publishedRule, err := rewriteRule(server.Rewrite, router.Rule)
+		if err != nil {
+			report.skip("tcp", router.Name, fmt.Sprintf("rule cannot be rewritten: %v", err))
+			continue
 		}
 
-		// Check if this router uses any of our entrypoints
-		var registeredEntryPoints int
-		for globalEP, localEP := range server.EntryPoints {
-			for _, routerEP := range router.EntryPoints {
-				if routerEP == localEP {
-					entries[getRedisKey("tcp", "routers", serviceName, "entrypoints", itoa(registeredEntryPoints))] = globalEP
-					registeredEntryPoints++
-				}
+		localEPs, groups := groupEntryPoints(server, router.EntryPoints)
+		for _, localEP := range localEPs {
+			port, ok := ports[localEP]
+			if !ok {
+				report.skip("tcp", router.Name, fmt.Sprintf("unknown port of entrypoint '%s'", localEP))
+				continue
+			}
+
+			// A router on several local entrypoints is split into one router per port
+			name, err := names.routerName(router.Name, localEP, len(localEPs) > 1)
+			if err != nil {
+				return fmt.Errorf("error naming router '%s': %w", router.Name, err)
+			}
+			localService, err := names.serviceName(router.Name, localEP, true)
+			if err != nil {
+				return fmt.Errorf("error naming service of router '%s': %w", router.Name, err)
 			}
+			report.sources.add("tcp", name, router.Name)
+
+			for i, globalEP := range groups[localEP] {
+				entries[getRedisKey("tcp", "routers", name, "entrypoints", itoa(i))] = globalEP
+			}
+			entries[getRedisKey("tcp", "routers", name, "rule")] = publishedRule
+			if priority, ok := server.GetServerPriority(router.Priority, publishedRule); ok {
+				entries[getRedisKey("tcp", "routers", name, "priority")] = strconv.FormatInt(priority, 10)
+			}
+			entries[getRedisKey("tcp", "routers", name, "service")] = localService
+			putTLS(entries, "tcp", name, rewriteTLS(server.Rewrite, tcpRouterTLS(server.GetServerTLS(), router.TLS, groups[localEP])))
+			for i, host := range hosts {
+				entries[getRedisKey("tcp", "services", localService, "loadbalancer", "servers", itoa(i), "address")] = net.JoinHostPort(host, port)
+			}
+			putTCPHealthCheck(entries, localService, server.HealthCheck)
 		}
+	}
 
-		// Only continue if this router is using our entrypoints
-		if registeredEntryPoints > 0 {
-			entries[getRedisKey("tcp", "routers", serviceName, "rule")] = router.Rule
-			entries[getRedisKey("tcp", "routers", serviceName, "service")] = server.Name
+	return nil
+}
+
+// processUdpRouters processes UDP routers for a server
+func (w *Worker) processUdpRouters(ctx context.Context, client *traefik.Client, server config.Server, names *namer, entries map[string]string, report *serverReport) error {
+	// Fetch UDP routers
+	routers, err := client.GetUdpRouters(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Retrieved %d UDP routers from server '%s'", len(routers), server.Name)
+
+	// If no routers, nothing to do
+	if len(routers) == 0 {
+		return nil
+	}
+
+	// UDP has no URLs, the load balancer needs the port the server listens on
+	hosts, ports, err := destinationPorts(ctx, client, server)
+	if err != nil {
+		return err
+	}
+
+	// Process each router
+	for _, router := range routers {
+		target := filterTarget(router.Name, router.Provider, router.Status, "", router.EntryPoints, nil)
+		if reason := w.skipReason(server, target, router.Error); reason != "" {
+			report.skip("udp", router.Name, reason)
+			continue
+		}
+
+		localEPs, groups := groupEntryPoints(server, router.EntryPoints)
+		for _, localEP := range localEPs {
+			port, ok := ports[localEP]
+			if !ok {
+				report.skip("udp", router.Name, fmt.Sprintf("unknown port of entrypoint '%s'", localEP))
+				continue
+			}
+
+			// A router on several local entrypoints is split into one router per port
+			name, err := names.routerName(router.Name, localEP, len(localEPs) > 1)
+			if err != nil {
+				return fmt.Errorf("error naming router '%s': %w", router.Name, err)
+			}
+			serviceName, err := names.serviceName(router.Name, localEP, true)
+			if err != nil {
+				return fmt.Errorf("error naming service of router '%s': %w", router.Name, err)
+			}
+			report.sources.add("udp", name, router.Name)
+
+			for i, globalEP := range groups[localEP] {
+				entries[getRedisKey("udp", "routers", name, "entrypoints", itoa(i))] = globalEP
+			}
+			entries[getRedisKey("udp", "routers", name, "service")] = serviceName
+			for i, host := range hosts {
+				entries[getRedisKey("udp", "services", serviceName, "loadbalancer", "servers", itoa(i), "address")] = net.JoinHostPort(host, port)
+			}
 		}
 	}
 
 	return nil
 }
 
+// destinationPorts returns the distinct destination hosts of a server and the
+// ports of its entrypoints, which TCP and UDP services point at. The ports
+// set by entryPointPorts take precedence over those the entrypoints listen on.
+func destinationPorts(ctx context.Context, client *traefik.Client, server config.Server) ([]string, map[string]string, error) {
+	var hosts []string
+	seen := make(map[string]bool)
+	weighted := false
+	for _, destination := range server.GetDestinations() {
+		host, err := destinationHost(destination.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+		weighted = weighted || destination.Weight > 0
+	}
+	if weighted {
+		// Traefik's TCP and UDP load balancers have no server weights
+		log.Printf("Ignoring destination weights of server '%s' for TCP and UDP services", server.Name)
+	}
+
+	entryPoints, err := client.GetEntryPoints(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching entrypoints: %w", err)
+	}
+	ports := make(map[string]string)
+	for _, ep := range entryPoints {
+		if port := entryPointPort(ep.Address); port != "" {
+			ports[ep.Name] = port
+		}
+	}
+
+	// Entrypoints published on other ports, e.g. by Docker, are reached there
+	for localEP, port := range server.EntryPointPorts {
+		ports[localEP] = strconv.Itoa(port)
+	}
+
+	return hosts, ports, nil
+}
+
+// groupEntryPoints groups the global entrypoints a router is published on by
+// the local entrypoint they map to, every local entrypoint listening on its
+// own port. Both are sorted so that indexes are stable between runs.
+func groupEntryPoints(server config.Server, routerEPs []string) ([]string, map[string][]string) {
+	globalEPs := make([]string, 0, len(server.EntryPoints))
+	for globalEP := range server.EntryPoints {
+		globalEPs = append(globalEPs, globalEP)
+	}
+	sort.Strings(globalEPs)
+
+	var localEPs []string
+	groups := make(map[string][]string)
+	for _, globalEP := range globalEPs {
+		localEP := server.EntryPoints[globalEP]
+		for _, routerEP := range routerEPs {
+			if routerEP != localEP {
+				continue
+			}
+			if _, ok := groups[localEP]; !ok {
+				localEPs = append(localEPs, localEP)
+			}
+			groups[localEP] = append(groups[localEP], globalEP)
+		}
+	}
+	sort.Strings(localEPs)
+
+	return localEPs, groups
+}
+
+// destinationHost returns the host of the destination address, which may be
+// given as a URL or as a plain host
+func destinationHost(address string) (string, error) {
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return "", fmt.Errorf("invalid destination address '%s': %w", address, err)
+		}
+		return u.Hostname(), nil
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host, nil
+	}
+	return address, nil
+}
+
+// entryPointPort returns the port of an entrypoint address like ":53/udp"
+func entryPointPort(address string) string {
+	address = strings.SplitN(address, "/", 2)[0]
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
 // getRedisKey joins multiple segments into a Redis key with the Traefik prefix
 func getRedisKey(segments ...string) string {
 	return "traefik/" + strings.Join(segments, "/")
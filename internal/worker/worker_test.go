@@ -0,0 +1,414 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hhftechnology/traefik-relay/internal/config"
+	"github.com/hhftechnology/traefik-relay/internal/traefik"
+)
+
+// fakeTraefik serves the API of a Traefik instance. UDP routers are not
+// served when udpRouters is nil, like Traefik without UDP entrypoints.
+type fakeTraefik struct {
+	httpRouters []traefik.HttpRouter
+	tcpRouters  []traefik.TcpRouter
+	udpRouters  []traefik.UdpRouter
+	entryPoints []traefik.EntryPoint
+	middlewares []traefik.Middleware
+	services    []traefik.Service
+}
+
+// start serves the API until the test ends and returns its address
+func (f *fakeTraefik) start(t *testing.T) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	serve := func(path string, value interface{}) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(value)
+		})
+	}
+	serve("/api/http/routers", f.httpRouters)
+	serve("/api/tcp/routers", f.tcpRouters)
+	serve("/api/entrypoints", f.entryPoints)
+	serve("/api/http/middlewares", f.middlewares)
+	serve("/api/http/services", f.services)
+	if f.udpRouters != nil {
+		serve("/api/udp/routers", f.udpRouters)
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// testConfig loads a configuration file with the given content, in which %s
+// is replaced by apiAddress
+func testConfig(t *testing.T, content, apiAddress string) *config.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(content, apiAddress)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	return cfg
+}
+
+// processFake processes the first server of the configuration against fake
+func processFake(t *testing.T, fake *fakeTraefik, content string) (map[string]string, *serverReport) {
+	t.Helper()
+
+	cfg := testConfig(t, content, fake.start(t))
+	entries, report, err := New(cfg, nil).processServer(context.Background(), cfg.Servers[0])
+	if err != nil {
+		t.Fatalf("processServer() error = %v", err)
+	}
+	return entries, report
+}
+
+// skipReasons maps the routers skipped by a run to the reason
+func skipReasons(report *serverReport) map[string]string {
+	reasons := make(map[string]string)
+	for _, router := range report.skipped {
+		reasons[router.Protocol+"/"+router.Router] = router.Reason
+	}
+	return reasons
+}
+
+func TestProcessHttpRouters(t *testing.T) {
+	routers := []traefik.HttpRouter{
+		{
+			Name:        "whoami@docker",
+			EntryPoints: []string{"websecure"},
+			Rule:        "Host(`whoami.lan`)",
+			Service:     "whoami@docker",
+			Middlewares: []string{"auth@file", "local@docker"},
+			Status:      "enabled",
+		},
+		{
+			Name:        "shared@docker",
+			EntryPoints: []string{"web"},
+			Rule:        "Host(`shared.lan`)",
+			Service:     "shared@file",
+			Status:      "enabled",
+		},
+		{Name: "dashboard@internal", EntryPoints: []string{"traefik"}, Rule: "PathPrefix(`/api`)", Service: "api@internal"},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]string
+	}{
+		{
+			name: "generated services",
+			config: `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    forwardMiddlewares: true
+    entryPoints:
+      websecure: websecure
+      web-main: web
+`,
+			want: map[string]string{
+				"traefik/http/routers/whoami_compute-1/entrypoints/0":        "websecure",
+				"traefik/http/routers/whoami_compute-1/rule":                 "Host(`whoami.lan`)",
+				"traefik/http/routers/whoami_compute-1/service":              "compute-1",
+				"traefik/http/routers/whoami_compute-1/middlewares/0":        "auth@file",
+				"traefik/http/routers/shared_compute-1/entrypoints/0":        "web-main",
+				"traefik/http/routers/shared_compute-1/rule":                 "Host(`shared.lan`)",
+				"traefik/http/routers/shared_compute-1/service":              "compute-1",
+				"traefik/http/services/compute-1/loadbalancer/servers/0/url": "http://10.0.0.2:80",
+			},
+		},
+		{
+			name: "forwarded services and weighted destinations",
+			config: `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinations:
+      - address: http://10.0.0.2:80
+        weight: 3
+      - address: http://10.0.0.3:80
+        weight: 1
+    forwardServices: true
+    entryPoints:
+      websecure: websecure
+      web-main: web
+`,
+			want: map[string]string{
+				"traefik/http/routers/whoami_compute-1/entrypoints/0":           "websecure",
+				"traefik/http/routers/whoami_compute-1/rule":                    "Host(`whoami.lan`)",
+				"traefik/http/routers/whoami_compute-1/service":                 "compute-1",
+				"traefik/http/routers/shared_compute-1/entrypoints/0":           "web-main",
+				"traefik/http/routers/shared_compute-1/rule":                    "Host(`shared.lan`)",
+				"traefik/http/routers/shared_compute-1/service":                 "shared@file",
+				"traefik/http/services/compute-1/loadbalancer/servers/0/url":    "http://10.0.0.2:80",
+				"traefik/http/services/compute-1/loadbalancer/servers/0/weight": "3",
+				"traefik/http/services/compute-1/loadbalancer/servers/1/url":    "http://10.0.0.3:80",
+				"traefik/http/services/compute-1/loadbalancer/servers/1/weight": "1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTraefik{
+				httpRouters: routers,
+				middlewares: []traefik.Middleware{{Name: "local@docker"}},
+				services:    []traefik.Service{{Name: "whoami@docker"}},
+			}
+			entries, _ := processFake(t, fake, tt.config)
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("entries = %v, want %v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessTcpRouters(t *testing.T) {
+	fake := &fakeTraefik{
+		tcpRouters: []traefik.TcpRouter{
+			{Name: "db@docker", EntryPoints: []string{"postgres"}, Rule: "HostSNI(`*`)", Service: "db@docker"},
+			{Name: "cache@docker", EntryPoints: []string{"redis"}, Rule: "HostSNI(`*`)", Service: "cache@docker"},
+		},
+		entryPoints: []traefik.EntryPoint{{Name: "postgres", Address: ":5432"}, {Name: "web", Address: ":80"}},
+	}
+
+	tests := []struct {
+		name        string
+		config      string
+		wantAddress string
+	}{
+		{
+			name: "port of the entrypoint",
+			config: `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      postgres: postgres
+      redis: redis
+`,
+			wantAddress: "10.0.0.2:5432",
+		},
+		{
+			name: "published port",
+			config: `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      postgres: postgres
+      redis: redis
+    entryPointPorts:
+      postgres: 15432
+`,
+			wantAddress: "10.0.0.2:15432",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, report := processFake(t, fake, tt.config)
+			want := map[string]string{
+				"traefik/tcp/routers/db_compute-1/entrypoints/0":                         "postgres",
+				"traefik/tcp/routers/db_compute-1/rule":                                  "HostSNI(`*`)",
+				"traefik/tcp/routers/db_compute-1/service":                               "compute-1_postgres",
+				"traefik/tcp/services/compute-1_postgres/loadbalancer/servers/0/address": tt.wantAddress,
+			}
+			if !reflect.DeepEqual(entries, want) {
+				t.Errorf("entries = %v, want %v", entries, want)
+			}
+
+			// The port of an entrypoint Traefik does not report is unknown
+			if reason := skipReasons(report)["tcp/cache@docker"]; reason != "unknown port of entrypoint 'redis'" {
+				t.Errorf("skip reason of cache@docker = %q", reason)
+			}
+		})
+	}
+}
+
+func TestProcessUdpRouters(t *testing.T) {
+	content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinations:
+      - address: http://10.0.0.2:80
+      - address: http://10.0.0.3:80
+    entryPoints:
+      dns: dns
+`
+
+	fake := &fakeTraefik{
+		udpRouters:  []traefik.UdpRouter{{Name: "dns@docker", EntryPoints: []string{"dns"}, Service: "dns@docker"}},
+		entryPoints: []traefik.EntryPoint{{Name: "dns", Address: ":53/udp"}},
+	}
+	entries, _ := processFake(t, fake, content)
+	want := map[string]string{
+		"traefik/udp/routers/dns_compute-1/entrypoints/0":                   "dns",
+		"traefik/udp/routers/dns_compute-1/service":                         "compute-1_dns",
+		"traefik/udp/services/compute-1_dns/loadbalancer/servers/0/address": "10.0.0.2:53",
+		"traefik/udp/services/compute-1_dns/loadbalancer/servers/1/address": "10.0.0.3:53",
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %v, want %v", entries, want)
+	}
+
+	// Traefik without UDP entrypoints does not serve UDP routers
+	entries, _ = processFake(t, &fakeTraefik{}, content)
+	if len(entries) != 0 {
+		t.Errorf("entries without UDP support = %v, want none", entries)
+	}
+}
+
+func TestRouterPriority(t *testing.T) {
+	fake := &fakeTraefik{
+		httpRouters: []traefik.HttpRouter{
+			{Name: "whoami@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`)"},
+			{Name: "api@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`) && PathPrefix(`/api`)", Priority: 50},
+		},
+		tcpRouters:  []traefik.TcpRouter{{Name: "db@docker", EntryPoints: []string{"postgres"}, Rule: "HostSNI(`*`)", Priority: 5}},
+		entryPoints: []traefik.EntryPoint{{Name: "postgres", Address: ":5432"}},
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]string
+	}{
+		{
+			name: "forwarded",
+			want: map[string]string{
+				"traefik/http/routers/api_compute-1/priority": "50",
+				"traefik/tcp/routers/db_compute-1/priority":   "5",
+			},
+		},
+		{
+			// The offset is added to the length of the rule for default priorities
+			name:   "offset",
+			config: "    priorityOffset: 100\n",
+			want: map[string]string{
+				"traefik/http/routers/whoami_compute-1/priority": "118",
+				"traefik/http/routers/api_compute-1/priority":    "150",
+				"traefik/tcp/routers/db_compute-1/priority":      "105",
+			},
+		},
+		{
+			name:   "override",
+			config: "    priorityOffset: 100\n    priorityOverride: 7\n",
+			want: map[string]string{
+				"traefik/http/routers/whoami_compute-1/priority": "7",
+				"traefik/http/routers/api_compute-1/priority":    "7",
+				"traefik/tcp/routers/db_compute-1/priority":      "7",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      web: web
+      postgres: postgres
+` + tt.config
+
+			entries, _ := processFake(t, fake, content)
+			got := make(map[string]string)
+			for key, value := range entries {
+				if strings.HasSuffix(key, "/priority") {
+					got[key] = value
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("priorities = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// memoryPublisher is a Publisher keeping the published entries in a map
+type memoryPublisher struct {
+	entries   map[string]string
+	loads     int
+	publishes int
+}
+
+func (p *memoryPublisher) Name() string { return "memory" }
+
+func (p *memoryPublisher) Load(ctx context.Context) (map[string]string, error) {
+	p.loads++
+	return copyEntries(p.entries), nil
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, entries, updated map[string]string, removed []string) error {
+	p.publishes++
+	p.entries = copyEntries(entries)
+	return nil
+}
+
+func (p *memoryPublisher) Close() error { return nil }
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeTraefik{httpRouters: []traefik.HttpRouter{
+		{Name: "whoami@docker", EntryPoints: []string{"web"}, Rule: "Host(`whoami.lan`)", Service: "whoami@docker"},
+	}}
+	cfg := testConfig(t, `
+servers:
+  - name: compute-1
+    apiAddress: %s
+    destinationAddress: http://10.0.0.2:80
+    entryPoints:
+      web: web
+`, fake.start(t))
+	publisher := &memoryPublisher{entries: map[string]string{"traefik/http/routers/stale_compute-1/rule": "Host(`stale`)"}}
+	w := New(cfg, []Publisher{publisher})
+
+	// A follower serves the entries without touching the backends
+	if _, err := w.Build(ctx); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	entries, _, ok := w.Entries()
+	if !ok || entries["traefik/http/routers/whoami_compute-1/rule"] != "Host(`whoami.lan`)" {
+		t.Errorf("Entries() = %v, %v, want the built entries", entries, ok)
+	}
+	if publisher.loads != 0 || publisher.publishes != 0 {
+		t.Errorf("follower loaded %d and published %d times", publisher.loads, publisher.publishes)
+	}
+
+	// Once elected, it starts from the published state and replaces it
+	w.Reset()
+	if _, err := w.Execute(ctx); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if publisher.loads != 1 || publisher.publishes != 1 {
+		t.Errorf("leader loaded %d and published %d times, want once each", publisher.loads, publisher.publishes)
+	}
+	if !reflect.DeepEqual(publisher.entries, entries) {
+		t.Errorf("published entries = %v, want %v", publisher.entries, entries)
+	}
+}